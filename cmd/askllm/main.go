@@ -1,38 +1,96 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/robinmin/askllm/internal/cache"
+	"github.com/robinmin/askllm/internal/catalog"
+	"github.com/robinmin/askllm/internal/chat"
+	"github.com/robinmin/askllm/internal/compare"
 	"github.com/robinmin/askllm/internal/config"
 	"github.com/robinmin/askllm/internal/llm"
 	"github.com/robinmin/askllm/internal/output"
 	"github.com/robinmin/askllm/internal/prompt"
+	"github.com/robinmin/askllm/internal/schema"
+	"github.com/robinmin/askllm/internal/server"
+	"github.com/robinmin/askllm/internal/session"
+	"github.com/robinmin/askllm/internal/tools"
+	"github.com/robinmin/askllm/internal/usage"
+	"github.com/robinmin/askllm/pkg/utils"
 	"github.com/robinmin/askllm/pkg/utils/log"
 )
 
 var (
 	// Define command-line flags
-	action     *string
-	engine     *string
-	model      *string
-	configFile *string
-	promptFile *string
-	outputFile *string
-	verbose    *bool
+	action       *string
+	engine       *string
+	model        *string
+	configFile   *string
+	promptFile   *string
+	systemPrompt *string
+	outputFile   *string
+	outputFormat *string
+	verbose      *bool
+	stream       *bool
+	sessionName  *string
+	continueLast *bool
+	temperature  *float64
+	topP         *float64
+	maxTokens    *int
+	stopWords    *string
+	seed         *int
+	noCache      *bool
+	refresh      *bool
+	recordFile   *string
+	replayFile   *string
+	capability   *string
+	minContext   *int
+	useTools     *bool
+	noStdin      *bool
+
+	// Text piped into stdin
+	stdinInput string
 )
 
+// stdinWait is how long the data piped into stdin is waited for unless "-" is given, as a pipe may never be closed
+const stdinWait = 2 * time.Second
+
 func init() {
-	action = flag.String("a", "client", "subcommand, so far support 'client', 'server', 'models'")
-	engine = flag.String("e", "", "LLM engine (chatgpt, gemini, ollama, claude, groq)")
+	action = flag.String("a", "client", "subcommand, so far support 'client', 'chat', 'server', 'models', 'sessions', 'usage', 'cache'")
+	engine = flag.String("e", "", "LLM engine (chatgpt, gemini, ollama, claude, groq, openai, mock, plugin, or any engine declared in the config file), or comma-separated engine:model pairs to compare")
 	model = flag.String("m", "", "Model for the LLM engine")
 	configFile = flag.String("c", "~/.askllm/config.yaml", "Locatuon of configuration file")
 	promptFile = flag.String("p", "", "Prompt file or prompt text")
+	systemPrompt = flag.String("s", "", "System prompt, overrides the one of the prompt template")
 	outputFile = flag.String("o", "", "Output file")
+	outputFormat = flag.String("f", "", "Output format (markdown, raw, json, yaml), json by default if the prompt declares a response schema")
 	verbose = flag.Bool("v", false, "verbose output")
+	stream = flag.Bool("stream", true, "stream tokens to the console as they arrive")
+	sessionName = flag.String("session", "", "Name of the session to continue or create")
+	continueLast = flag.Bool("continue", false, "Continue the last session")
+	temperature = flag.Float64("temperature", llm.DefaultTemperature, "Sampling temperature, overrides the prompt template and engine config")
+	topP = flag.Float64("top-p", 0, "Nucleus sampling probability mass, overrides the prompt template and engine config")
+	maxTokens = flag.Int("max-tokens", 0, "Maximum number of tokens to generate, overrides the prompt template and engine config")
+	stopWords = flag.String("stop", "", "Comma-separated stop sequences, overrides the prompt template and engine config")
+	seed = flag.Int("seed", 0, "Seed for deterministic sampling, overrides the prompt template and engine config")
+	noCache = flag.Bool("no-cache", false, "Bypass the response cache")
+	refresh = flag.Bool("refresh", false, "List the models by the providers even if the cached listings are fresh")
+	recordFile = flag.String("record", "", "Record the HTTP traffic of the LLM engines into the cassette file, with API keys redacted")
+	capability = flag.String("capability", "", "Comma-separated capabilities to filter the models by (tools, json, or a modality such as image)")
+	minContext = flag.Int("min-context", 0, "Minimum context window in tokens to filter the models by")
+	useTools = flag.Bool("tools", false, "Let the model read files, list directories, grep and run the allowed commands, each call confirmed unless approved in the config")
+	replayFile = flag.String("replay", "", "Replay the HTTP traffic of the LLM engines from the cassette file instead of the network")
+	noStdin = flag.Bool("no-stdin", false, "Do not read the piped input unless \"-\" is given as an argument")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s (version %s):\n", os.Args[0], config.VERSION)
@@ -41,6 +99,14 @@ func init() {
 }
 
 func main() {
+	// exit with a failure once all the deferred cleanups are done, as os.Exit skips them
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	// Parse command-line flags
 	flag.Parse()
 
@@ -65,17 +131,62 @@ func main() {
 		log.Debugf("currentDir = %v", currentDir)
 	}
 
+	cassette, err := openCassette()
+	if err != nil {
+		log.Error("Error opening cassette: " + err.Error())
+		return
+	}
+	if cassette != nil {
+		utils.SetTransport(cassette)
+		defer func() {
+			if err := cassette.Save(); err != nil {
+				log.Error("Error saving cassette: " + err.Error())
+			}
+		}()
+	}
+
 	startTime := time.Now()
 	log.Info("Starting askllm...(engine: " + *engine + ", model: " + *model + " @ " + config.VERSION + ")")
-	payload := strings.Join(flag.Args(), " ")
+	args := flag.Args()
+
+	// read the piped input for the client action
+	if strings.ToLower(*action) == "client" {
+		stdinInput, args, err = readStdin(args, cfg)
+		if err != nil {
+			log.Error("Error reading stdin: " + err.Error())
+			return
+		}
+	}
+	payload := strings.Join(args, " ")
+
+	// cancel the running requests on interrupt, except for the chat which aborts the running turn only. The
+	// default handling is restored then, so that a second interrupt kills a request ignoring the cancellation.
+	ctx := context.Background()
+	if strings.ToLower(*action) != "chat" {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+	}
 
 	switch strings.ToLower(*action) {
 	case "client":
-		err = runClientAction(*promptFile, payload, *engine, *model, cfg)
+		err = runClientAction(ctx, *promptFile, payload, *engine, *model, cfg)
+	case "chat":
+		err = runChatAction(ctx, *promptFile, payload, *engine, *model, cfg)
 	case "server":
-		err = runServerAction(*promptFile, payload, *engine, *model, cfg)
+		err = runServerAction(ctx, *promptFile, payload, *engine, *model, cfg)
 	case "models":
-		err = runModelsAction(*promptFile, payload, *engine, *model, cfg)
+		err = runModelsAction(ctx, *promptFile, payload, *engine, *model, cfg)
+	case "sessions":
+		err = runSessionsAction(ctx, *promptFile, payload, *engine, *model, cfg)
+	case "usage":
+		err = runUsageAction(ctx, *promptFile, payload, *engine, *model, cfg)
+	case "cache":
+		err = runCacheAction(ctx, *promptFile, payload, *engine, *model, cfg)
 	default:
 		log.Error("Invalid action: " + *action)
 		err = fmt.Errorf("invalid action: %s", *action)
@@ -83,76 +194,834 @@ func main() {
 	}
 	if err != nil {
 		log.Error("Error: " + err.Error())
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		exitCode = 1
 	}
 
 	elapsedTime := time.Since(startTime)
 	log.Info(fmt.Sprintf("============== DONE ==============(%s)", elapsedTime))
 }
 
-func runClientAction(promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+// openCassette opens the cassette to record or replay the HTTP traffic, or returns nil if none is given
+func openCassette() (*utils.Cassette, error) {
+	switch {
+	case *recordFile != "" && *replayFile != "":
+		return nil, fmt.Errorf("-record and -replay cannot be used together")
+	case *recordFile != "":
+		return utils.NewCassette(*recordFile, utils.CassetteRecord)
+	case *replayFile != "":
+		return utils.NewCassette(*replayFile, utils.CassetteReplay)
+	default:
+		return nil, nil
+	}
+}
+
+// readStdin reads the piped input if "-" is given as an argument, or if stdin is a file, or a pipe with data
+// within stdinWait, and returns the remaining arguments
+func readStdin(args []string, cfg *config.Config) (string, []string, error) {
+	explicit := false
+	remaining := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "-" {
+			explicit = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+
+	var input io.Reader = os.Stdin
+	if !explicit {
+		if *noStdin {
+			return "", remaining, nil
+		}
+		stat, err := os.Stdin.Stat()
+		if err != nil || stat.Mode()&os.ModeCharDevice != 0 {
+			return "", remaining, nil
+		}
+		if !stat.Mode().IsRegular() {
+			var ok bool
+			if input, ok = waitForInput(os.Stdin, stdinWait); !ok {
+				log.Warnf("No input on stdin within %v, ignoring it; give \"-\" to wait for it", stdinWait)
+				return "", remaining, nil
+			}
+		}
+	}
+
+	text, err := prompt.ReadInput(input, cfg.Sys.MaxInputSize)
+	if err != nil {
+		return "", remaining, err
+	}
+	log.Debugf("Read %d bytes from stdin", len(text))
+	return text, remaining, nil
+}
+
+// waitForInput waits for the first data or the end of r within the timeout, and returns the reader of all its data.
+// Once timed out, the data arriving later is dropped.
+func waitForInput(r io.Reader, timeout time.Duration) (io.Reader, bool) {
+	first := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		n, _ := r.Read(buf) // the error, if any, is returned again by the next read
+		first <- buf[:n]
+	}()
+
+	select {
+	case data := <-first:
+		return io.MultiReader(bytes.NewReader(data), r), true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+func runClientAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+	ctx, cancel := withTimeout(ctx, cfg.Sys.Timeout)
+	defer cancel()
+
+	if compare.IsCompareSpec(engine) {
+		if model != "" {
+			return fmt.Errorf("-m cannot be used to compare engines, give the models as engine:model pairs to -e")
+		}
+		return runCompareAction(ctx, promptFile, payload, engine, cfg)
+	}
+
 	// load prompt from external file (compatible with old version)
-	pt, promptText, err := prompt.GeneratePrompt(promptFile, payload)
+	pt, promptText, err := prompt.GeneratePromptWithInput(ctx, promptFile, payload, stdinInput)
 	if err != nil {
 		log.Error("Error getting prompt: " + err.Error())
 		return err
 	}
+	if strings.TrimSpace(promptText) == "" {
+		return fmt.Errorf("empty prompt")
+	}
+
+	// load the session to continue, or start a new one
+	store, err := session.NewStore(cfg.Sys.SessionPath)
+	if err != nil {
+		log.Error("Error opening session store: " + err.Error())
+		return err
+	}
+	sess, err := loadSession(store)
+	if err != nil {
+		log.Error("Error loading session: " + err.Error())
+		return err
+	}
+
+	// keep using the engine of the session unless specified
+	if engine == "" && len(sess.Turns) > 0 {
+		lastTurn := sess.Turns[len(sess.Turns)-1]
+		engine = lastTurn.Engine
+		if model == "" {
+			model = lastTurn.Model
+		}
+	}
 
 	// // Initialize LLM engine
-	realEngine, realModel := pt.GetParameters(engine, model, cfg.Sys.DefaultEngine, llm.GetDefaultModel(cfg.Sys.DefaultEngine))
+	realEngine, realModel := pt.GetParameters(engine, model, cfg.Sys.DefaultEngine, llm.GetDefaultModel(cfg.Sys.DefaultEngine), cfg.Aliases)
+	if err := validateModel(ctx, realEngine, realModel, cfg); err != nil {
+		log.Error("Error resolving model: " + err.Error())
+		return err
+	}
+	realEngine, realModel = llm.ResolveEngine(realEngine, realModel, cfg)
 	llmEngine, err := llm.NewEngine(realEngine, realModel, cfg)
 	if err != nil {
 		log.Error("Error initializing LLM engine: " + err.Error())
 		return err
 	}
+	defer llm.CloseEngine(llmEngine)
+	if !*noCache && !cfg.Cache.Disabled {
+		if store, err := openCache(cfg); err != nil {
+			log.Warn("Response cache disabled: " + err.Error())
+		} else {
+			llmEngine = cache.Wrap(llmEngine, store, realEngine, realModel, cfg.LLMEngines[realEngine].GenerationParams)
+		}
+	}
+
+	// keep using the system prompt of the session unless specified
+	system := resolveSystem(pt)
+	if system == "" && len(sess.Turns) > 0 {
+		system = sess.Turns[len(sess.Turns)-1].System
+	}
+
+	// replay the prior turns of the session as context
+	messages := llm.PrependSystem(system, append(sess.Messages(), llm.Message{Role: llm.RoleUser, Content: promptText}))
+	turn := session.Turn{
+		Prompt:     payload,
+		PromptFile: promptFile,
+		System:     system,
+		Rendered:   promptText,
+		Engine:     realEngine,
+		Model:      realModel,
+		StartedAt:  time.Now(),
+	}
+
+	options := []llm.CallOption{llm.WithContext(ctx), llm.WithParams(cliParams(), pt.Parameters)}
+
+	// reply in JSON if a response schema is declared
+	format := strings.ToLower(*outputFormat)
+	if format == "" {
+		format = output.FormatMarkdown
+		if pt.ResponseSchema != nil {
+			format = output.FormatJSON
+		}
+	}
+
+	toolbox, closeToolbox, err := openToolbox(realEngine, realModel, cfg)
+	if err != nil {
+		log.Error("Error opening tools: " + err.Error())
+		return err
+	}
+	defer closeToolbox()
+	if toolbox != nil && pt.ResponseSchema != nil {
+		log.Warn("The prompt template declares a response_schema, -tools is ignored")
+		toolbox = nil
+	}
+
+	var response *llm.Response
+	if toolbox != nil {
+		// Query LLM with the tools, the answer is output once complete
+		var onChunk llm.StreamFunc
+		sw := output.NewStreamWriter()
+		toConsole := *stream && format == output.FormatMarkdown && (*outputFile == "" || *outputFile == "stdout")
+		if toConsole {
+			onChunk = sw.Write
+		}
+		response, _, err = tools.Run(llmEngine, messages, toolbox, onChunk, options...)
+		if err != nil {
+			log.Error("Error querying LLM: " + err.Error())
+			return err
+		}
+		if toConsole {
+			err = sw.Finish(response.Content)
+		} else {
+			err = output.HandleOutput(*outputFile, response.Content, format)
+		}
+		if err != nil {
+			log.Error("Error handling output: " + err.Error())
+			return err
+		}
+	} else if pt.ResponseSchema != nil {
+		// Query LLM for a response conforming to the schema
+		validator, err := schema.Compile(pt.ResponseSchema)
+		if err != nil {
+			log.Error("Error compiling response schema: " + err.Error())
+			return err
+		}
+		retries := pt.SchemaRetries
+		if retries <= 0 {
+			retries = schema.DefaultRetries
+		}
+		response, err = schema.Query(llmEngine, messages, validator, retries, options...)
+		if err != nil {
+			log.Error("Error querying LLM: " + err.Error())
+			return err
+		}
+		if err := output.HandleOutput(*outputFile, response.Content, format); err != nil {
+			log.Error("Error handling output: " + err.Error())
+			return err
+		}
+	} else if *stream && format == output.FormatMarkdown && (*outputFile == "" || *outputFile == "stdout") {
+		// Stream the response into console if no output file specified
+		sw := output.NewStreamWriter()
+		response, err = llmEngine.ChatStream(messages, sw.Write, options...)
+		if err != nil {
+			log.Error("Error querying LLM: " + err.Error())
+			return err
+		}
+		if err := sw.Finish(response.Content); err != nil {
+			log.Error("Error handling output: " + err.Error())
+			return err
+		}
+	} else {
+		// Query LLM
+		response, err = llm.Chat(llmEngine, messages, options...)
+		if err != nil {
+			log.Error("Error querying LLM: " + err.Error())
+			return err
+		}
+
+		// Handle output
+		if err := output.HandleOutput(*outputFile, response.Content, format); err != nil {
+			log.Error("Error handling output: " + err.Error())
+			return err
+		}
+	}
+
+	// record the engine of the fallback chain answered
+	if response.Engine != "" {
+		turn.Engine = response.Engine
+		turn.Model = response.Model
+	}
+	turn.Response = response.Content
+	turn.Usage = response.Usage
+	turn.Cached = response.Cached
+	turn.FinishedAt = time.Now()
+	if *verbose {
+		fmt.Fprintln(os.Stderr, usage.Footer(cfg, usage.Record{
+			Engine:  turn.Engine,
+			Model:   turn.Model,
+			Usage:   turn.Usage,
+			Latency: turn.FinishedAt.Sub(turn.StartedAt),
+			Cached:  turn.Cached,
+		}))
+	}
+	sess.AddTurn(turn)
+	if err := store.Save(sess); err != nil {
+		log.Error("Error saving session: " + err.Error())
+		return err
+	}
+	log.Infof("Saved as session %s (%d turns)", sess.Name, len(sess.Turns))
+	return nil
+}
+
+// runCompareAction runs the prompt against several engine:model pairs and reports the results side by side
+func runCompareAction(ctx context.Context, promptFile string, payload string, engineSpec string, cfg *config.Config) error {
+	pt, promptText, err := prompt.GeneratePromptWithInput(ctx, promptFile, payload, stdinInput)
+	if err != nil {
+		log.Error("Error getting prompt: " + err.Error())
+		return err
+	}
 
-	// Query LLM
-	response, err := llmEngine.Query(promptText)
+	// the model of a target defaults to the one the engine is created with, e.g. the model of the engine config
+	targets, err := compare.ParseTargets(engineSpec, func(engine string) string {
+		_, model := llm.ResolveEngine(engine, "", cfg)
+		return model
+	})
 	if err != nil {
-		log.Error("Error querying LLM: " + err.Error())
+		log.Error("Error parsing engines: " + err.Error())
+		return err
+	}
+	var unknown []error
+	for _, target := range targets {
+		if err := catalog.ValidateEngine(target.Engine, cfg); err != nil {
+			unknown = append(unknown, err)
+		}
+	}
+	if err := errors.Join(unknown...); err != nil {
+		log.Error("Error parsing engines: " + err.Error())
 		return err
 	}
 
-	// Handle output
-	if err := output.HandleOutput(*outputFile, response); err != nil {
+	log.Infof("Comparing %d engines/models...", len(targets))
+	results := compare.Run(targets, llm.PrependSystem(resolveSystem(pt), []llm.Message{{Role: llm.RoleUser, Content: promptText}}), cfg, llm.NewEngine, llm.WithContext(ctx), llm.WithParams(cliParams(), pt.Parameters))
+
+	// the results are reported side by side in markdown, or as a document in JSON or YAML
+	format := strings.ToLower(*outputFormat)
+	content := compare.Report(promptText, results)
+	if format == output.FormatJSON || format == output.FormatYAML {
+		if content, err = compare.Document(promptText, results); err != nil {
+			log.Error("Error handling output: " + err.Error())
+			return err
+		}
+	}
+	if err := output.HandleOutput(*outputFile, content, format); err != nil {
 		log.Error("Error handling output: " + err.Error())
 		return err
 	}
 	return nil
 }
 
-func runServerAction(promptFile string, payload string, engine string, model string, cfg *config.Config) error {
-	log.Error("Not implemented")
-	return fmt.Errorf("not implemented")
+// resolveSystem returns the system prompt from the command line, or the one of the prompt template
+func resolveSystem(pt *prompt.PromptTemplate) string {
+	if *systemPrompt != "" {
+		return *systemPrompt
+	}
+	return pt.SystemPrompt()
+}
+
+// cliParams collects the generation parameters explicitly set on the command line
+func cliParams() config.GenerationParams {
+	var params config.GenerationParams
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "temperature":
+			params.Temperature = temperature
+		case "top-p":
+			params.TopP = topP
+		case "max-tokens":
+			params.MaxTokens = maxTokens
+		case "stop":
+			params.Stop = strings.Split(*stopWords, ",")
+		case "seed":
+			params.Seed = seed
+		}
+	})
+	return params
+}
+
+// loadSession returns the session specified by the command line flags, or a new one
+func loadSession(store *session.Store) (*session.Session, error) {
+	if *continueLast {
+		return store.Last()
+	}
+	if *sessionName != "" {
+		return store.LoadOrCreate(*sessionName)
+	}
+	return session.NewSession(""), nil
+}
+
+func runSessionsAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+	store, err := session.NewStore(cfg.Sys.SessionPath)
+	if err != nil {
+		log.Error("Error opening session store: " + err.Error())
+		return err
+	}
+
+	args := strings.Fields(payload)
+	command := "list"
+	if len(args) > 0 {
+		command = strings.ToLower(args[0])
+	}
+	if command != "list" && len(args) < 2 {
+		return fmt.Errorf("usage: -a sessions %s <name>", command)
+	}
+
+	var content string
+	switch command {
+	case "list":
+		sessions, err := store.List()
+		if err != nil {
+			log.Error("Error listing sessions: " + err.Error())
+			return err
+		}
+		lines := []string{"| Session | Turns | Last engine/model | Updated at |", "| --- | --- | --- | --- |"}
+		for _, sess := range sessions {
+			lastModel := ""
+			if len(sess.Turns) > 0 {
+				lastTurn := sess.Turns[len(sess.Turns)-1]
+				lastModel = lastTurn.Engine + "/" + lastTurn.Model
+			}
+			lines = append(lines, fmt.Sprintf("| %s | %d | %s | %s |", sess.Name, len(sess.Turns), lastModel, sess.UpdatedAt.Format(time.DateTime)))
+		}
+		content = strings.Join(lines, "\n")
+	case "show":
+		sess, err := store.Load(args[1])
+		if err != nil {
+			log.Error("Error loading session: " + err.Error())
+			return err
+		}
+		content = sess.Markdown()
+	case "delete":
+		if err := store.Delete(args[1]); err != nil {
+			log.Error("Error deleting session: " + err.Error())
+			return err
+		}
+		content = "Session " + args[1] + " deleted."
+	default:
+		return fmt.Errorf("invalid sessions command: %s, so far support 'list', 'show', 'delete'", command)
+	}
+
+	if err := output.OutputMarkdown(content); err != nil {
+		log.Error("Error in output markdown : " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// withTimeout bounds the context by the timeout if positive
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func openCache(cfg *config.Config) (*cache.Store, error) {
+	return cache.NewStore(cfg.Cache.Path, cfg.Cache.TTL, cfg.Cache.MaxSize)
+}
+
+// openModelStore opens the cache of the model listings, or returns nil with a warning if it cannot be opened
+func openModelStore(cfg *config.Config) *cache.ModelStore {
+	store, err := cache.NewModelStore(cfg.Cache.Path, cfg.Cache.ModelsTTL)
+	if err != nil {
+		log.Warnf("Model listings are not cached: %v", err)
+		return nil
+	}
+	return store
+}
+
+// runCacheAction shows the statistics of the response cache, or clears it along with the cached model listings
+func runCacheAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+	store, err := openCache(cfg)
+	if err != nil {
+		log.Error("Error opening response cache: " + err.Error())
+		return err
+	}
+
+	command := strings.ToLower(strings.TrimSpace(payload))
+	var content string
+	switch command {
+	case "", "stats":
+		stats, err := store.Stats()
+		if err != nil {
+			log.Error("Error reading response cache: " + err.Error())
+			return err
+		}
+		lines := []string{
+			"| Entries | Expired | Size | Oldest | Newest |",
+			"| --- | --- | --- | --- | --- |",
+			fmt.Sprintf("| %d | %d | %.1f KB | %s | %s |", stats.Entries, stats.Expired, float64(stats.Size)/1024,
+				formatTime(stats.Oldest), formatTime(stats.Newest)),
+		}
+		content = strings.Join(lines, "\n")
+	case "clear":
+		count, err := store.Clear()
+		if err != nil {
+			log.Error("Error clearing response cache: " + err.Error())
+			return err
+		}
+		content = fmt.Sprintf("Removed %d cached responses.", count)
+		if modelStore := openModelStore(cfg); modelStore != nil {
+			listings, err := modelStore.Clear()
+			if err != nil {
+				log.Error("Error clearing cached model listings: " + err.Error())
+				return err
+			}
+			content += fmt.Sprintf(" Removed %d cached model listings.", listings)
+		}
+	default:
+		return fmt.Errorf("invalid cache command: %s, so far support 'stats', 'clear'", command)
+	}
+
+	if err := output.OutputMarkdown(content); err != nil {
+		log.Error("Error in output markdown : " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
+
+// runUsageAction reports the token usage and estimated cost over all sessions, or the named session
+func runUsageAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+	store, err := session.NewStore(cfg.Sys.SessionPath)
+	if err != nil {
+		log.Error("Error opening session store: " + err.Error())
+		return err
+	}
+
+	var sessions []*session.Session
+	title := "all sessions"
+	if name := strings.TrimSpace(payload); name != "" {
+		sess, err := store.Load(name)
+		if err != nil {
+			log.Error("Error loading session: " + err.Error())
+			return err
+		}
+		sessions = []*session.Session{sess}
+		title = "session " + name
+	} else {
+		sessions, err = store.List()
+		if err != nil {
+			log.Error("Error listing sessions: " + err.Error())
+			return err
+		}
+	}
+
+	summaries := usage.Aggregate(cfg, usage.FromSessions(sessions))
+	content := fmt.Sprintf("#### Usage of %s\n\n%s", title, usage.Report(summaries))
+	if err := output.OutputMarkdown(content); err != nil {
+		log.Error("Error in output markdown : " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func runChatAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+	// use the prompt (if any) as the first turn of the conversation
+	var firstPrompt string
+	pt := &prompt.PromptTemplate{}
+	if len(promptFile) > 0 || len(payload) > 0 {
+		promptCtx, cancel := withTimeout(ctx, cfg.Sys.Timeout)
+		defer cancel()
+		var err error
+		pt, firstPrompt, err = prompt.GeneratePrompt(promptCtx, promptFile, payload)
+		if err != nil {
+			log.Error("Error getting prompt: " + err.Error())
+			return err
+		}
+		if pt == nil {
+			pt = &prompt.PromptTemplate{}
+		}
+	}
+
+	realEngine, realModel := pt.GetParameters(engine, model, cfg.Sys.DefaultEngine, "", cfg.Aliases)
+	if err := validateModel(ctx, realEngine, realModel, cfg); err != nil {
+		log.Error("Error resolving model: " + err.Error())
+		return err
+	}
+	realEngine, realModel = llm.ResolveEngine(realEngine, realModel, cfg)
+	toolbox, closeToolbox, err := openToolbox(realEngine, realModel, cfg)
+	if err != nil {
+		log.Error("Error opening tools: " + err.Error())
+		return err
+	}
+	defer closeToolbox()
+	repl := chat.NewREPL(cfg, realEngine, realModel, os.Stdin, os.Stdout).
+		WithCallOptions(llm.WithParams(cliParams(), pt.Parameters)).
+		WithSystemPrompt(resolveSystem(pt)).
+		WithToolbox(toolbox)
+	if err := repl.Run(ctx, firstPrompt); err != nil {
+		log.Error("Error running chat: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func runServerAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+	srv := server.NewServer(cfg, engine, model)
+	if err := srv.ListenAndServe(ctx, cfg.Server.Addr); err != nil {
+		log.Error("Error running server: " + err.Error())
+		return err
+	}
+	return nil
 }
 
-func runModelsAction(promptFile string, payload string, engine string, model string, cfg *config.Config) error {
+func runModelsAction(ctx context.Context, promptFile string, payload string, engine string, model string, cfg *config.Config) error {
 	log.Info("List models for LLM engine: " + engine)
 
-	// if engine == "" {
-	// 	engine = cfg.Sys.DefaultEngine
-	// }
-	allModels, err := llm.GetAllModels(engine, cfg)
+	args := strings.Fields(payload)
+	command := "list"
+	if len(args) > 0 {
+		command = strings.ToLower(args[0])
+	}
+	if command != "list" && command != "remote" && len(args) < 2 {
+		return fmt.Errorf("usage: -a models %s <model>", command)
+	}
+	if command == "list" {
+		return listAllModels(ctx, engine, cfg)
+	}
+
+	ollama, err := ollamaEngine(engine, cfg)
 	if err != nil {
-		log.Error("Error listing models: " + err.Error())
 		return err
 	}
 
-	var content []string
-	for engineName, modelList := range allModels {
-		content = append(content, "#### "+engineName+":\n")
-		defaultModel := llm.GetDefaultModel(engineName)
-		for _, tmpModel := range modelList {
-			if tmpModel == defaultModel {
-				content = append(content, "- [*] "+tmpModel)
-			} else {
-				content = append(content, "- "+tmpModel)
+	var content string
+	switch command {
+	case "remote":
+		models, err := remoteModels(ctx, ollama, engine, cfg)
+		if err != nil {
+			log.Error("Error listing remote models: " + err.Error())
+			return err
+		}
+		lines := []string{"| Model | Description | Pulls | Tags | Updated |", "| --- | --- | --- | --- | --- |"}
+		for _, item := range models {
+			lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s | %s |", item.Name, item.Description, item.Pulls, item.Tags, item.Updated))
+		}
+		content = strings.Join(lines, "\n")
+	case "pull":
+		lastStatus := ""
+		err := ollama.PullModel(ctx, args[1], func(progress llm.OllamaPullProgress) {
+			if progress.Total > 0 {
+				fmt.Fprintf(os.Stderr, "\r%s: %d%%", progress.Status, progress.Completed*100/progress.Total)
+			} else if progress.Status != lastStatus {
+				fmt.Fprintf(os.Stderr, "\n%s", progress.Status)
 			}
+			lastStatus = progress.Status
+		})
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Error("Error pulling model: " + err.Error())
+			return err
+		}
+		content = fmt.Sprintf("Pulled model %s.", args[1])
+	case "delete":
+		if err := ollama.DeleteModel(ctx, args[1]); err != nil {
+			log.Error("Error deleting model: " + err.Error())
+			return err
 		}
-		content = append(content, "")
+		content = fmt.Sprintf("Deleted model %s.", args[1])
+	case "show":
+		info, err := ollama.ShowModel(ctx, args[1])
+		if err != nil {
+			log.Error("Error showing model: " + err.Error())
+			return err
+		}
+		lines := []string{
+			"## " + args[1],
+			"",
+			"| Family | Parameters | Quantization | Format |",
+			"| --- | --- | --- | --- |",
+			fmt.Sprintf("| %s | %s | %s | %s |", info.Details.Family, info.Details.ParameterSize, info.Details.QuantizationLevel, info.Details.Format),
+		}
+		if info.Parameters != "" {
+			lines = append(lines, "", "### Parameters", "", "```text", strings.TrimSpace(info.Parameters), "```")
+		}
+		if info.Template != "" {
+			lines = append(lines, "", "### Template", "", "```text", strings.TrimSpace(info.Template), "```")
+		}
+		content = strings.Join(lines, "\n")
+	default:
+		return fmt.Errorf("invalid models command: %s, so far support 'list', 'remote', 'pull', 'delete', 'show'", command)
 	}
-	if err := output.OutputMarkdown(strings.Join(content, "\n")); err != nil {
+
+	if err := output.OutputMarkdown(content); err != nil {
 		log.Error("Error in output markdown : " + err.Error())
 		return err
 	}
 	return nil
 }
+
+// listAllModels renders the models of the engine, or of all engines if none is given, merged with the model
+// catalog and filtered by the command line flags. The engines are listed concurrently, and the section of each
+// engine is rendered in the order of the engine names as soon as it is listed. The engines failing to list their
+// models are reported in their sections, and fail the command only if all of them fail with nothing to fall back to.
+func listAllModels(ctx context.Context, engine string, cfg *config.Config) error {
+	cat, err := catalog.Load(cfg.Sys.CatalogPath)
+	if err != nil {
+		log.Error("Error loading model catalog: " + err.Error())
+		return err
+	}
+	filter := catalog.Filter{Capabilities: catalog.ParseCapabilities(*capability), MinContext: *minContext}
+
+	defaults := map[string]string{}
+	for engineName, engineCfg := range cfg.LLMEngines {
+		defaults[engineName] = llm.GetDefaultModel(engineName)
+		if defaults[engineName] == "" {
+			defaults[engineName] = engineCfg.Model
+		}
+	}
+
+	var outputErr error
+	listings := catalog.List(ctx, engine, cfg, cat, catalog.ListOptions{
+		Store:   openModelStore(cfg),
+		Refresh: *refresh,
+		Timeout: cfg.Sys.ListTimeout,
+		OnListing: func(listing catalog.Listing) {
+			if outputErr == nil {
+				outputErr = output.OutputMarkdown(modelSection(listing, filter, defaults))
+			}
+		},
+	})
+	if outputErr != nil {
+		log.Error("Error in output markdown : " + outputErr.Error())
+		return outputErr
+	}
+
+	var failures []error
+	for _, listing := range listings {
+		if listing.Err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", listing.Engine, listing.Err))
+		}
+	}
+	if len(failures) > 0 && len(catalog.Models(listings)) == 0 {
+		return errors.Join(failures...)
+	}
+	return nil
+}
+
+// modelSection renders the filtered models of the listing of an engine, along with its failure if any
+func modelSection(listing catalog.Listing, filter catalog.Filter, defaults map[string]string) string {
+	content := fmt.Sprintf("## %s\n\n%s", listing.Engine, catalog.Table(filter.Apply(listing.Models), defaults))
+	if listing.Err != nil {
+		fallback := "the catalog"
+		if listing.Source == catalog.SourceCache {
+			fallback = "the cached listing of " + formatTime(listing.FetchedAt)
+		}
+		content += fmt.Sprintf("\n\n> Failed to list the models (%v), showing %s instead.", listing.Err, fallback)
+	}
+	return content
+}
+
+// openToolbox creates the toolbox of the working directory if -tools is given, or returns nil if not or if the engine
+// cannot call tools. The calls of the tools not approved by the config are confirmed on the terminal, or denied if
+// there is none. The returned function closes the terminal once done with the toolbox.
+func openToolbox(engine, model string, cfg *config.Config) (*tools.Toolbox, func(), error) {
+	noop := func() {}
+	if !*useTools {
+		return nil, noop, nil
+	}
+	engineName, _ := llm.ResolveEngine(engine, model, cfg)
+	if kind, ok := llm.LookupKind(llm.KindName(engineName, cfg.LLMEngines[engineName])); ok && !kind.Capabilities.Tools {
+		log.Warnf("Engine %s cannot call tools, -tools is ignored", engineName)
+		return nil, noop, nil
+	}
+
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		log.Warnf("No terminal to confirm the tool calls, only the approved tools will run: %v", err)
+		toolbox, err := tools.NewToolbox(cfg, ".", nil)
+		return toolbox, noop, err
+	}
+	closeTTY := func() {
+		if err := tty.Close(); err != nil {
+			log.Warnf("Failed to close the terminal: %v", err)
+		}
+	}
+	toolbox, err := tools.NewToolbox(cfg, ".", tools.TerminalConfirm(tty, os.Stderr))
+	if err != nil {
+		closeTTY()
+		return nil, noop, err
+	}
+	return toolbox, closeTTY, nil
+}
+
+// validateModel checks that the engine is configured and the model is known by it. The model is not checked if the
+// HTTP traffic is replayed from a cassette.
+func validateModel(ctx context.Context, engine, model string, cfg *config.Config) error {
+	if *replayFile != "" {
+		return catalog.ValidateEngine(engine, cfg)
+	}
+	cat, err := catalog.Load(cfg.Sys.CatalogPath)
+	if err != nil {
+		return err
+	}
+	return catalog.Validate(ctx, engine, model, cfg, cat, catalog.ListOptions{Store: openModelStore(cfg), Refresh: *refresh, Timeout: cfg.Sys.ListTimeout})
+}
+
+// remoteModels lists the models of the Ollama library, by the cached listing of the engine if it is fresh. The
+// cached listing is used as well, stale or not, if the library is unreachable.
+func remoteModels(ctx context.Context, ollama *llm.Ollama, engine string, cfg *config.Config) ([]llm.OllamaModel, error) {
+	engineName := strings.TrimSpace(strings.ToLower(engine))
+	if engineName == "" {
+		engineName = "ollama"
+	}
+	key := engineName + "-library"
+
+	store := openModelStore(cfg)
+	if store == nil {
+		return ollama.ListRemoteModels(ctx)
+	}
+	cached := cache.GetListing[llm.OllamaModel](store, key)
+	if cached != nil && !*refresh && store.Fresh(cached.FetchedAt) {
+		return cached.Models, nil
+	}
+
+	models, err := ollama.ListRemoteModels(ctx)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		log.Warnf("Failed to list the models of the Ollama library, using the cached listing of %s instead: %v",
+			formatTime(cached.FetchedAt), err)
+		return cached.Models, nil
+	}
+	if err := cache.PutListing(store, key, models); err != nil {
+		log.Warnf("Failed to cache the models of the Ollama library: %v", err)
+	}
+	return models, nil
+}
+
+// isOllama reports whether the engine is configured and of the ollama kind
+func isOllama(engine string, cfg *config.Config) bool {
+	engineName := strings.TrimSpace(strings.ToLower(engine))
+	engineCfg, ok := cfg.LLMEngines[engineName]
+	return ok && llm.KindName(engineName, engineCfg) == "ollama"
+}
+
+// ollamaEngine creates the Ollama engine to manage the models of, ollama by default
+func ollamaEngine(engine string, cfg *config.Config) (*llm.Ollama, error) {
+	engineName := strings.TrimSpace(strings.ToLower(engine))
+	if engineName == "" {
+		engineName = "ollama"
+	}
+	if !isOllama(engineName, cfg) {
+		return nil, fmt.Errorf("model management is only supported by engines of the ollama kind, not %s", engineName)
+	}
+	return llm.NewOllama("", cfg.LLMEngines[engineName])
+}
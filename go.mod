@@ -11,8 +11,11 @@ require (
 	github.com/creasty/defaults v1.7.0
 	github.com/dusted-go/logging v1.2.2
 	github.com/hashicorp/go-retryablehttp v0.7.7
+	github.com/mattn/go-runewidth v0.0.15
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/stretchr/testify v1.9.0
 	github.com/tmc/langchaingo v0.1.12
+	golang.org/x/term v0.21.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -46,7 +49,6 @@ require (
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/microcosm-cc/bluemonday v1.0.26 // indirect
 	github.com/muesli/reflow v0.3.0 // indirect
 	github.com/muesli/termenv v0.15.2 // indirect
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+const fileExt = ".json"
+
+// entry is a cached response persisted as a JSON file named by its key
+type entry struct {
+	Engine    string    `json:"engine"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	Content   string    `json:"content"`
+}
+
+// Stats summarizes the content of the cache
+type Stats struct {
+	Entries int
+	Expired int
+	Size    int64     // Total size in bytes
+	Oldest  time.Time // Creation time of the oldest entry
+	Newest  time.Time // Creation time of the newest entry
+}
+
+// Store keeps the responses on disk for ttl, evicting the least recently used ones beyond maxSize bytes
+type Store struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+}
+
+func NewStore(dir string, ttl time.Duration, maxSize int64) (*Store, error) {
+	if dir == "" {
+		dir = config.DefaultCachePath
+	}
+	if ttl <= 0 {
+		ttl = config.DefaultCacheTTL
+	}
+	if maxSize <= 0 {
+		maxSize = config.DefaultCacheMaxSize
+	}
+	absolutePath, err := config.ExpandTilde(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absolutePath, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache folder %s: %v", absolutePath, err)
+	}
+	return &Store{dir: absolutePath, ttl: ttl, maxSize: maxSize}, nil
+}
+
+// Key hashes everything that makes a difference to the response
+func Key(engine, model string, messages []llm.Message, params config.GenerationParams, jsonMode bool) string {
+	data, _ := json.Marshal(struct {
+		Engine   string                  `json:"engine"`
+		Model    string                  `json:"model"`
+		Messages []llm.Message           `json:"messages"`
+		Params   config.GenerationParams `json:"params"`
+		JSONMode bool                    `json:"json_mode"`
+	}{engine, model, messages, params, jsonMode})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (st *Store) path(key string) string {
+	return filepath.Join(st.dir, key+fileExt)
+}
+
+func readEntry(file string) (*entry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Get returns the cached response of the key unless it is missing or expired. No token is spent on a
+// cached response, hence its usage is empty.
+func (st *Store) Get(key string) (*llm.Response, bool) {
+	file := st.path(key)
+	e, err := readEntry(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Removed corrupted cache entry %s: %v", key, err)
+			_ = os.Remove(file)
+		}
+		return nil, false
+	}
+	if time.Since(e.CreatedAt) > st.ttl {
+		_ = os.Remove(file)
+		return nil, false
+	}
+
+	// keep track of the last access for the eviction
+	now := time.Now()
+	_ = os.Chtimes(file, now, now)
+	return &llm.Response{Content: e.Content, Engine: e.Engine, Model: e.Model, Cached: true}, true
+}
+
+// Put saves the response of the engine and model, then evicts the least recently used entries if the
+// cache grows too large
+func (st *Store) Put(key string, engine string, model string, response *llm.Response) error {
+	if response.Engine != "" {
+		engine, model = response.Engine, response.Model
+	}
+	data, err := json.Marshal(entry{Engine: engine, Model: model, CreatedAt: time.Now(), Content: response.Content})
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that a reader never sees a partial entry
+	if err := writeFileAtomic(st.path(key), data); err != nil {
+		return err
+	}
+	return st.evict()
+}
+
+// writeFileAtomic writes the data into a temporary file of the same directory, then renames it to the file
+func writeFileAtomic(file string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+	}
+	return err
+}
+
+type fileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (st *Store) files() ([]fileInfo, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]fileInfo, 0, len(entries))
+	for _, item := range entries {
+		if item.IsDir() || !strings.HasSuffix(item.Name(), fileExt) {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fileInfo{path: filepath.Join(st.dir, item.Name()), size: info.Size(), modTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// evict removes the least recently used entries until the cache fits into maxSize
+func (st *Store) evict() error {
+	files, err := st.files()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	if total <= st.maxSize {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		if total <= st.maxSize {
+			break
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= file.size
+		log.Debugf("Evicted cache entry %s", filepath.Base(file.path))
+	}
+	return nil
+}
+
+// Stats counts the entries and their total size
+func (st *Store) Stats() (Stats, error) {
+	var stats Stats
+	files, err := st.files()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, file := range files {
+		e, err := readEntry(file.path)
+		if err != nil {
+			continue
+		}
+		stats.Entries++
+		stats.Size += file.size
+		if time.Since(e.CreatedAt) > st.ttl {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || e.CreatedAt.Before(stats.Oldest) {
+			stats.Oldest = e.CreatedAt
+		}
+		if e.CreatedAt.After(stats.Newest) {
+			stats.Newest = e.CreatedAt
+		}
+	}
+	return stats, nil
+}
+
+// Clear removes all entries, and returns the number of entries removed
+func (st *Store) Clear() (int, error) {
+	files, err := st.files()
+	if err != nil {
+		return 0, err
+	}
+	for i, file := range files {
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return i, err
+		}
+	}
+	return len(files), nil
+}
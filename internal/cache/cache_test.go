@@ -0,0 +1,161 @@
+package cache_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	testee "github.com/robinmin/askllm/internal/cache"
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+)
+
+func messages(prompt string) []llm.Message {
+	return []llm.Message{{Role: llm.RoleUser, Content: prompt}}
+}
+
+func TestKey(t *testing.T) {
+	temperature := 0.7
+	key := testee.Key("groq", "llama3", messages("hello"), config.GenerationParams{}, false)
+	assert.Len(t, key, 64)
+	assert.Equal(t, key, testee.Key("groq", "llama3", messages("hello"), config.GenerationParams{}, false))
+
+	for name, other := range map[string]string{
+		"Engine":   testee.Key("chatgpt", "llama3", messages("hello"), config.GenerationParams{}, false),
+		"Model":    testee.Key("groq", "gemma2", messages("hello"), config.GenerationParams{}, false),
+		"Prompt":   testee.Key("groq", "llama3", messages("hello!"), config.GenerationParams{}, false),
+		"Params":   testee.Key("groq", "llama3", messages("hello"), config.GenerationParams{Temperature: &temperature}, false),
+		"JSONMode": testee.Key("groq", "llama3", messages("hello"), config.GenerationParams{}, true),
+	} {
+		assert.NotEqual(t, key, other, name)
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Run("PutAndGet", func(t *testing.T) {
+		store, err := testee.NewStore(t.TempDir(), time.Hour, 0)
+		assert.NoError(t, err)
+
+		_, ok := store.Get("missing")
+		assert.False(t, ok)
+
+		assert.NoError(t, store.Put("k1", "groq", "llama3", &llm.Response{Content: "hi", Usage: llm.Usage{PromptTokens: 1}}))
+		response, ok := store.Get("k1")
+		assert.True(t, ok)
+		assert.Equal(t, &llm.Response{Content: "hi", Engine: "groq", Model: "llama3", Cached: true}, response)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		store, err := testee.NewStore(t.TempDir(), time.Millisecond, 0)
+		assert.NoError(t, err)
+
+		assert.NoError(t, store.Put("k1", "groq", "llama3", &llm.Response{Content: "hi"}))
+		time.Sleep(5 * time.Millisecond)
+		stats, err := store.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.Expired)
+
+		_, ok := store.Get("k1")
+		assert.False(t, ok)
+	})
+
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		dir := t.TempDir()
+		// room for three entries of about 1KB each
+		content := strings.Repeat("x", 1000)
+		store, err := testee.NewStore(dir, time.Hour, 3500)
+		assert.NoError(t, err)
+
+		old := time.Now().Add(-time.Minute)
+		for i, key := range []string{"k1", "k2", "k3"} {
+			assert.NoError(t, store.Put(key, "groq", "llama3", &llm.Response{Content: content}))
+			modTime := old.Add(time.Duration(i) * time.Second)
+			assert.NoError(t, os.Chtimes(filepath.Join(dir, key+".json"), modTime, modTime))
+		}
+
+		// k1 is used recently, so that k2 is the least recently used one
+		_, ok := store.Get("k1")
+		assert.True(t, ok)
+		assert.NoError(t, store.Put("k4", "groq", "llama3", &llm.Response{Content: content}))
+
+		_, ok = store.Get("k2")
+		assert.False(t, ok)
+		for _, key := range []string{"k1", "k4"} {
+			_, ok = store.Get(key)
+			assert.True(t, ok, key)
+		}
+	})
+
+	t.Run("StatsAndClear", func(t *testing.T) {
+		store, err := testee.NewStore(t.TempDir(), time.Hour, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, store.Put("k1", "groq", "llama3", &llm.Response{Content: "a"}))
+		assert.NoError(t, store.Put("k2", "groq", "llama3", &llm.Response{Content: "b"}))
+
+		stats, err := store.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, stats.Entries)
+		assert.Equal(t, 0, stats.Expired)
+		assert.Positive(t, stats.Size)
+		assert.False(t, stats.Newest.Before(stats.Oldest))
+
+		count, err := store.Clear()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		stats, err = store.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, stats.Entries)
+	})
+}
+
+func TestEngine(t *testing.T) {
+	store, err := testee.NewStore(t.TempDir(), time.Hour, 0)
+	assert.NoError(t, err)
+	calls := 0
+	wrapped := llmtest.New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+		calls++
+		last := messages[len(messages)-1].Content
+		if last == "fail" {
+			return nil, fmt.Errorf("provider is down")
+		}
+		return &llm.Response{Content: fmt.Sprintf("answer #%d to %s", calls, last), Usage: llm.Usage{PromptTokens: 3, CompletionTokens: 4}}, nil
+	})
+	engine := testee.Wrap(wrapped, store, "groq", "llama3", config.GenerationParams{})
+
+	first, err := llm.Chat(engine, messages("hello"))
+	assert.NoError(t, err)
+	assert.False(t, first.Cached)
+
+	// the cached response is streamed at once
+	var chunks []string
+	second, err := engine.ChatStream(messages("hello"), func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, second.Cached)
+	assert.Equal(t, first.Content, second.Content)
+	assert.Equal(t, llm.Usage{}, second.Usage)
+	assert.Equal(t, []string{first.Content}, chunks)
+	assert.Equal(t, 1, calls)
+
+	// different parameters miss the cache
+	temperature := 0.9
+	third, err := llm.Chat(engine, messages("hello"), llm.WithParams(config.GenerationParams{Temperature: &temperature}, config.GenerationParams{}))
+	assert.NoError(t, err)
+	assert.False(t, third.Cached)
+	assert.Equal(t, 2, calls)
+
+	// errors are not cached
+	_, err = llm.Chat(engine, messages("fail"))
+	assert.Error(t, err)
+	_, err = llm.Chat(engine, messages("fail"))
+	assert.Error(t, err)
+	assert.Equal(t, 4, calls)
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"io"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// Engine serves the responses from the cache if possible, and caches the responses of the wrapped engine
+type Engine struct {
+	engine     llm.Engine
+	store      *Store
+	engineName string
+	model      string
+	params     config.GenerationParams // Generation parameters of the engine config
+}
+
+func Wrap(engine llm.Engine, store *Store, engineName string, model string, params config.GenerationParams) *Engine {
+	return &Engine{
+		engine:     engine,
+		store:      store,
+		engineName: engineName,
+		model:      model,
+		params:     params,
+	}
+}
+
+// ChatStream sends a cached response to onChunk at once. The queries with tools are not cached, as the results of
+// the tools may change.
+func (e *Engine) ChatStream(messages []llm.Message, onChunk llm.StreamFunc, options ...llm.CallOption) (*llm.Response, error) {
+	callOpts := llm.NewCallOptions(options...)
+	if len(callOpts.Tools) > 0 {
+		return e.engine.ChatStream(messages, onChunk, options...)
+	}
+	key := Key(e.engineName, e.model, messages, callOpts.Params(e.params), callOpts.JSONMode)
+
+	if response, ok := e.store.Get(key); ok {
+		log.Infof("Served from cache: %s", key)
+		if onChunk != nil {
+			if err := onChunk(response.Content); err != nil {
+				return nil, err
+			}
+		}
+		return response, nil
+	}
+
+	response, err := e.engine.ChatStream(messages, onChunk, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.store.Put(key, e.engineName, e.model, response); err != nil {
+		log.Warnf("Failed to cache the response: %v", err)
+	}
+	return response, nil
+}
+
+func (e *Engine) ListAllModels(options ...llm.CallOption) ([]string, error) {
+	return e.engine.ListAllModels(options...)
+}
+
+// Close closes the wrapped engine
+func (e *Engine) Close() error {
+	if closer, ok := e.engine.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// modelsFolder is the subfolder of the cache keeping the model listings
+const modelsFolder = "models"
+
+// Listing is a model listing persisted as a JSON file named by its key, usually the engine name
+type Listing[T any] struct {
+	Key       string    `json:"key"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Models    []T       `json:"models"`
+}
+
+// ModelStore keeps the model listings of the engines on disk. Listings older than ttl are stale, but
+// are kept to be served when the provider is unreachable.
+type ModelStore struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewModelStore(dir string, ttl time.Duration) (*ModelStore, error) {
+	if dir == "" {
+		dir = config.DefaultCachePath
+	}
+	if ttl <= 0 {
+		ttl = config.DefaultModelsTTL
+	}
+	absolutePath, err := config.ExpandTilde(dir)
+	if err != nil {
+		return nil, err
+	}
+	absolutePath = filepath.Join(absolutePath, modelsFolder)
+	if err := os.MkdirAll(absolutePath, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache folder %s: %v", absolutePath, err)
+	}
+	return &ModelStore{dir: absolutePath, ttl: ttl}, nil
+}
+
+func (st *ModelStore) path(key string) string {
+	return filepath.Join(st.dir, url.PathEscape(key)+fileExt)
+}
+
+// Fresh reports whether the listing was fetched within the ttl
+func (st *ModelStore) Fresh(fetchedAt time.Time) bool {
+	return time.Since(fetchedAt) <= st.ttl
+}
+
+// GetListing returns the cached listing of the key, stale or not, or nil if there is none
+func GetListing[T any](st *ModelStore, key string) *Listing[T] {
+	file := st.path(key)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var listing Listing[T]
+	if err := json.Unmarshal(data, &listing); err != nil {
+		log.Warnf("Removed corrupted model listing %s: %v", key, err)
+		_ = os.Remove(file)
+		return nil
+	}
+	return &listing
+}
+
+// PutListing saves the models as the listing of the key fetched now
+func PutListing[T any](st *ModelStore, key string, models []T) error {
+	data, err := json.Marshal(Listing[T]{Key: key, FetchedAt: time.Now(), Models: models})
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that a reader never sees a partial listing
+	return writeFileAtomic(st.path(key), data)
+}
+
+// Clear removes all the listings, and returns the number of removed ones
+func (st *ModelStore) Clear() (int, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, item := range entries {
+		if item.IsDir() || filepath.Ext(item.Name()) != fileExt {
+			continue
+		}
+		if err := os.Remove(filepath.Join(st.dir, item.Name())); err != nil && !os.IsNotExist(err) {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
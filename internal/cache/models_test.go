@@ -0,0 +1,41 @@
+package cache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	testee "github.com/robinmin/askllm/internal/cache"
+	"github.com/robinmin/askllm/internal/llm"
+)
+
+func TestModelStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := testee.NewModelStore(dir, time.Hour)
+	assert.NoError(t, err)
+
+	assert.Nil(t, testee.GetListing[llm.ModelInfo](store, "groq"))
+
+	models := []llm.ModelInfo{{ID: "llama3-8b", ContextWindow: 8192, Listed: true}}
+	assert.NoError(t, testee.PutListing(store, "groq", models))
+	listing := testee.GetListing[llm.ModelInfo](store, "groq")
+	assert.NotNil(t, listing)
+	assert.Equal(t, "groq", listing.Key)
+	assert.Equal(t, models, listing.Models)
+	assert.True(t, store.Fresh(listing.FetchedAt))
+	assert.False(t, store.Fresh(time.Now().Add(-2*time.Hour)))
+
+	// a corrupted listing is dropped
+	file := filepath.Join(dir, "models", "gemini.json")
+	assert.NoError(t, os.WriteFile(file, []byte("{"), 0o644))
+	assert.Nil(t, testee.GetListing[llm.ModelInfo](store, "gemini"))
+	assert.NoFileExists(t, file)
+
+	count, err := store.Clear()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Nil(t, testee.GetListing[llm.ModelInfo](store, "groq"))
+}
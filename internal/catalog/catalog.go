@@ -0,0 +1,277 @@
+package catalog
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/robinmin/askllm/internal/cache"
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+//go:embed catalog.yaml
+var bundled []byte
+
+// Catalog is the metadata of the known models, keyed by engine (name or kind) and model id
+type Catalog struct {
+	Models []llm.ModelInfo `yaml:"models"`
+}
+
+// Bundled returns the catalog shipped with askllm
+func Bundled() (*Catalog, error) {
+	var cat Catalog
+	if err := yaml.Unmarshal(bundled, &cat); err != nil {
+		return nil, fmt.Errorf("invalid bundled catalog: %v", err)
+	}
+	return &cat, nil
+}
+
+// Load returns the bundled catalog overridden by the catalog file, if it exists. An entry of the file replaces
+// the bundled one of the same engine and model id as a whole, or is added if there is none.
+func Load(path string) (*Catalog, error) {
+	cat, err := Bundled()
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		path = config.DefaultCatalogPath
+	}
+	absolutePath, err := config.ExpandTilde(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(absolutePath)
+	if os.IsNotExist(err) {
+		return cat, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog %s: %v", absolutePath, err)
+	}
+
+	var overrides Catalog
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %v", absolutePath, err)
+	}
+	cat.override(&overrides)
+	return cat, nil
+}
+
+func (c *Catalog) override(other *Catalog) {
+	for _, model := range other.Models {
+		replaced := false
+		for i := range c.Models {
+			if strings.EqualFold(c.Models[i].Engine, model.Engine) && c.Models[i].ID == model.ID {
+				c.Models[i] = model
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.Models = append(c.Models, model)
+		}
+	}
+}
+
+// Lookup returns the entry of the model of the engine, matching the engine by name first, then by kind. A model
+// id with a tag (e.g. gemma2:latest of ollama) matches the entry without the tag as well.
+func (c *Catalog) Lookup(engine, kind, id string) (llm.ModelInfo, bool) {
+	ids := []string{id}
+	if name, _, ok := strings.Cut(id, ":"); ok {
+		ids = append(ids, name)
+	}
+
+	for _, engineKey := range []string{engine, kind} {
+		for _, candidate := range ids {
+			for _, model := range c.Models {
+				if strings.EqualFold(model.Engine, engineKey) && model.ID == candidate {
+					return model, true
+				}
+			}
+		}
+	}
+	return llm.ModelInfo{}, false
+}
+
+// entries returns the entries of the engine by name, or by kind if there is none
+func (c *Catalog) entries(engine, kind string) []llm.ModelInfo {
+	for _, engineKey := range []string{engine, kind} {
+		var result []llm.ModelInfo
+		for _, model := range c.Models {
+			if strings.EqualFold(model.Engine, engineKey) {
+				result = append(result, model)
+			}
+		}
+		if len(result) > 0 {
+			return result
+		}
+	}
+	return nil
+}
+
+// Merge enriches the models listed by the engine with the catalog, the listed metadata taking precedence. A model
+// missing from the catalog gets the capabilities of the engine kind. If the engine listed none, the catalog entries
+// of the engine are returned instead.
+func (c *Catalog) Merge(engine, kind string, listed []llm.ModelInfo) []llm.ModelInfo {
+	if len(listed) == 0 {
+		result := c.entries(engine, kind)
+		for i := range result {
+			result[i].Engine = engine
+		}
+		return result
+	}
+
+	engineKind, _ := llm.LookupKind(kind)
+	result := make([]llm.ModelInfo, 0, len(listed))
+	for _, model := range listed {
+		merged, ok := c.Lookup(engine, kind, model.ID)
+		if !ok {
+			merged = llm.ModelInfo{JSONMode: engineKind.Capabilities.JSONMode, Tools: engineKind.Capabilities.Tools}
+		}
+		merged.Engine = engine
+		merged.ID = model.ID
+		merged.Listed = true
+		if model.Description != "" {
+			merged.Description = model.Description
+		}
+		if model.ContextWindow > 0 {
+			merged.ContextWindow = model.ContextWindow
+		}
+		if model.MaxOutputTokens > 0 {
+			merged.MaxOutputTokens = model.MaxOutputTokens
+		}
+		result = append(result, merged)
+	}
+	return result
+}
+
+// Sources of the models of a listing
+const (
+	SourceProvider = "provider" // Listed by the provider
+	SourceCache    = "cache"    // Listed by the provider earlier, and kept in the cache
+	SourceCatalog  = "catalog"  // Entries of the catalog, the provider listing none
+)
+
+// Listing is the models of an engine, along with the failure to list them by the provider if any
+type Listing struct {
+	Engine    string
+	Models    []llm.ModelInfo
+	Source    string
+	FetchedAt time.Time // Time the models were listed by the provider, zero for the catalog
+	Err       error     // Failure to list the models by the provider, the models coming from the cache or catalog
+}
+
+// ListOptions are the options of listing the models
+type ListOptions struct {
+	Store     *cache.ModelStore // Cache of the provider listings, none if nil
+	Refresh   bool              // List the models by the provider even if the cached listing is fresh
+	Timeout   time.Duration     // Time limit for an engine to list its models, the default one if zero
+	Offline   bool              // Do not list the models by the provider, but fall back to the cache or the catalog
+	OnListing func(Listing)     // Called with each listing in order as soon as it and the preceding ones are available
+}
+
+// errOffline is the failure of the listings not listed by the provider, as they are offline
+var errOffline = errors.New("not listed offline")
+
+// List describes the models of the engine, or of all engines if it is not configured, merged with the catalog. The
+// engines are listed concurrently, and their listings are returned sorted by engine name. A fresh listing of the
+// cache is used unless refreshed. An engine failing to list its models in time falls back to its cached listing,
+// stale or not, then to the catalog, with the failure reported in its listing. Prices of the config take precedence
+// over the catalog. All engines are given up on once ctx is done.
+func List(ctx context.Context, engine string, cfg *config.Config, cat *Catalog, opts ListOptions) []Listing {
+	engineNames := []string{strings.TrimSpace(strings.ToLower(engine))}
+	if _, ok := cfg.LLMEngines[engineNames[0]]; !ok {
+		engineNames = engineNames[:0]
+		for name := range cfg.LLMEngines {
+			engineNames = append(engineNames, name)
+		}
+		sort.Strings(engineNames)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = config.DefaultListTimeout
+	}
+
+	// read the cached listings upfront, to fall back to them once an engine has failed or timed out
+	cached := map[string]*cache.Listing[llm.ModelInfo]{}
+	if opts.Store != nil {
+		for _, engineName := range engineNames {
+			if listing := cache.GetListing[llm.ModelInfo](opts.Store, engineName); listing != nil {
+				cached[engineName] = listing
+			}
+		}
+	}
+
+	result := make([]Listing, 0, len(engineNames))
+	llm.ForEachEngine(ctx, engineNames, opts.Timeout, func(ctx context.Context, engineName string) (Listing, error) {
+		if listing := cached[engineName]; listing != nil && !opts.Refresh && opts.Store.Fresh(listing.FetchedAt) {
+			return Listing{Engine: engineName, Models: listing.Models, Source: SourceCache, FetchedAt: listing.FetchedAt}, nil
+		}
+		if opts.Offline {
+			return Listing{}, errOffline
+		}
+		listed, err := describe(ctx, engineName, cfg)
+		if err != nil {
+			return Listing{}, err
+		}
+		if len(listed) == 0 {
+			return Listing{Engine: engineName, Source: SourceCatalog}, nil
+		}
+		return Listing{Engine: engineName, Models: listed, Source: SourceProvider, FetchedAt: time.Now()}, nil
+	}, func(fetched llm.EngineResult[Listing]) {
+		listing := fetched.Value
+		switch {
+		case fetched.Err == nil && listing.Source == SourceProvider && opts.Store != nil:
+			if err := cache.PutListing(opts.Store, fetched.Engine, listing.Models); err != nil {
+				log.Warnf("Failed to cache the models of %s: %v", fetched.Engine, err)
+			}
+		case fetched.Err != nil && cached[fetched.Engine] != nil:
+			stale := cached[fetched.Engine]
+			listing = Listing{Engine: fetched.Engine, Models: stale.Models, Source: SourceCache, FetchedAt: stale.FetchedAt, Err: fetched.Err}
+		case fetched.Err != nil:
+			listing = Listing{Engine: fetched.Engine, Source: SourceCatalog, Err: fetched.Err}
+		}
+		if listing.Err != nil && !errors.Is(listing.Err, errOffline) {
+			log.Warnf("Failed to list the models of %s, using the %s instead: %v", listing.Engine, listing.Source, listing.Err)
+		}
+
+		listing.Models = cat.Merge(listing.Engine, llm.KindName(listing.Engine, cfg.LLMEngines[listing.Engine]), listing.Models)
+		for i := range listing.Models {
+			if price, ok := cfg.Price(listing.Engine, listing.Models[i].ID); ok {
+				listing.Models[i].Pricing = &price
+			}
+		}
+		result = append(result, listing)
+		if opts.OnListing != nil {
+			opts.OnListing(listing)
+		}
+	})
+	return result
+}
+
+// Models returns the models of all listings
+func Models(listings []Listing) []llm.ModelInfo {
+	var result []llm.ModelInfo
+	for _, listing := range listings {
+		result = append(result, listing.Models...)
+	}
+	return result
+}
+
+// describe lists the models of the engine by its provider
+func describe(ctx context.Context, engineName string, cfg *config.Config) ([]llm.ModelInfo, error) {
+	llmObj, err := llm.NewEngine(engineName, "", cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer llm.CloseEngine(llmObj)
+	return llm.DescribeModels(llmObj, llm.WithContext(ctx))
+}
@@ -0,0 +1,288 @@
+package catalog_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/cache"
+	testee "github.com/robinmin/askllm/internal/catalog"
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+)
+
+func TestBundled(t *testing.T) {
+	cat, err := testee.Bundled()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cat.Models)
+	for _, model := range cat.Models {
+		assert.NotEmpty(t, model.Engine)
+		assert.NotEmpty(t, model.ID)
+	}
+
+	model, ok := cat.Lookup("claude", "claude", "claude-3-5-sonnet-20240620")
+	assert.True(t, ok)
+	assert.Equal(t, 200000, model.ContextWindow)
+	assert.Equal(t, 8192, model.MaxOutputTokens)
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("MissingFile", func(t *testing.T) {
+		cat, err := testee.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.NoError(t, err)
+		bundled, _ := testee.Bundled()
+		assert.Equal(t, bundled, cat)
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "catalog.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte(`models:
+  - engine: claude
+    id: claude-3-haiku-20240307
+    context_window: 100
+  - engine: deepseek
+    id: deepseek-chat
+    context_window: 65536
+    tools: true
+`), 0o644))
+
+		cat, err := testee.Load(file)
+		assert.NoError(t, err)
+
+		model, ok := cat.Lookup("claude", "claude", "claude-3-haiku-20240307")
+		assert.True(t, ok)
+		assert.Equal(t, 100, model.ContextWindow)
+		assert.Empty(t, model.Modalities) // replaced as a whole
+
+		model, ok = cat.Lookup("deepseek", "openai", "deepseek-chat")
+		assert.True(t, ok)
+		assert.True(t, model.Tools)
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "catalog.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte("models: {"), 0o644))
+		_, err := testee.Load(file)
+		assert.Error(t, err)
+	})
+}
+
+func TestCatalog_Lookup(t *testing.T) {
+	cat, _ := testee.Bundled()
+
+	// a tagged ollama model matches the entry without tag
+	model, ok := cat.Lookup("ollama", "ollama", "llama3.1:latest")
+	assert.True(t, ok)
+	assert.Equal(t, "llama3.1", model.ID)
+
+	// an engine declared by the config matches the entries of its kind
+	model, ok = cat.Lookup("fastgroq", "groq", "llama3-70b-8192")
+	assert.True(t, ok)
+	assert.Equal(t, "groq", model.Engine)
+
+	_, ok = cat.Lookup("groq", "groq", "unknown")
+	assert.False(t, ok)
+}
+
+func TestCatalog_Merge(t *testing.T) {
+	cat, _ := testee.Bundled()
+
+	t.Run("Listed", func(t *testing.T) {
+		models := cat.Merge("gemini", "gemini", []llm.ModelInfo{
+			{ID: "gemini-1.5-flash", Description: "Gemini 1.5 Flash (live)", ContextWindow: 1000000, Listed: true},
+			{ID: "gemini-exp", Listed: true},
+		})
+		assert.Len(t, models, 2)
+
+		assert.Equal(t, "gemini", models[0].Engine)
+		assert.Equal(t, "Gemini 1.5 Flash (live)", models[0].Description)
+		assert.Equal(t, 1000000, models[0].ContextWindow) // listed metadata takes precedence
+		assert.Equal(t, 8192, models[0].MaxOutputTokens)  // filled by the catalog
+		assert.True(t, models[0].Tools)
+
+		// an uncatalogued model gets the capabilities of the engine kind
+		assert.Equal(t, "gemini-exp", models[1].ID)
+		assert.True(t, models[1].Listed)
+		assert.True(t, models[1].Tools)
+		assert.False(t, models[1].JSONMode)
+	})
+
+	t.Run("UncataloguedCapabilities", func(t *testing.T) {
+		models := cat.Merge("local", "ollama", []llm.ModelInfo{{ID: "my-finetune", Listed: true}})
+		assert.Len(t, models, 1)
+		assert.True(t, models[0].JSONMode)
+		assert.False(t, models[0].Tools)
+
+		models = cat.Merge("fastgroq", "groq", []llm.ModelInfo{{ID: "new-groq-model", Listed: true}})
+		assert.True(t, models[0].JSONMode)
+		assert.True(t, models[0].Tools)
+	})
+
+	t.Run("CatalogOnly", func(t *testing.T) {
+		models := cat.Merge("fastgroq", "groq", nil)
+		assert.NotEmpty(t, models)
+		for _, model := range models {
+			assert.Equal(t, "fastgroq", model.Engine)
+			assert.False(t, model.Listed)
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	models := []llm.ModelInfo{
+		{ID: "small", ContextWindow: 8192, JSONMode: true, Modalities: []string{"text"}},
+		{ID: "vision", ContextWindow: 128000, Tools: true, Modalities: []string{"text", "image"}},
+		{ID: "unknown"},
+	}
+	ids := func(models []llm.ModelInfo) []string {
+		var result []string
+		for _, model := range models {
+			result = append(result, model.ID)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"small", "vision", "unknown"}, ids(testee.Filter{}.Apply(models)))
+	assert.Equal(t, []string{"vision"}, ids(testee.Filter{MinContext: 100000}.Apply(models)))
+	assert.Equal(t, []string{"small"}, ids(testee.Filter{Capabilities: testee.ParseCapabilities("json")}.Apply(models)))
+	assert.Equal(t, []string{"vision"}, ids(testee.Filter{Capabilities: testee.ParseCapabilities(" Tools , image")}.Apply(models)))
+	assert.Empty(t, testee.Filter{Capabilities: []string{"audio"}}.Apply(models))
+}
+
+func TestListAndTable(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{"mock": {}},
+		Pricing:    map[string]config.ModelPrice{"mock/echo": {Input: 1, Output: 2}},
+	}
+	cat, _ := testee.Bundled()
+
+	listings := testee.List(context.Background(), "", cfg, cat, testee.ListOptions{})
+	assert.Len(t, listings, 1)
+	assert.NoError(t, listings[0].Err)
+	assert.Equal(t, testee.SourceProvider, listings[0].Source)
+	models := testee.Models(listings)
+	assert.Len(t, models, len(llm.MockModels))
+	assert.Equal(t, &config.ModelPrice{Input: 1, Output: 2}, models[0].Pricing)
+
+	table := testee.Table(models, map[string]string{"mock": "echo"})
+	lines := strings.Split(table, "\n")
+	assert.Len(t, lines, 2+len(llm.MockModels))
+	assert.Equal(t, "| mock | [*] echo |  | - | - |  | yes | - | 1 / 2 |", lines[2])
+
+	table = testee.Table([]llm.ModelInfo{{Engine: "claude", ID: "claude-2.1", Description: "Claude 2.1", ContextWindow: 200000,
+		MaxOutputTokens: 4096, Modalities: []string{"text"}, Tools: true, Deprecated: true}}, nil)
+	assert.Contains(t, table, "| claude | claude-2.1 | Claude 2.1 (deprecated) | 200K | 4K | text | yes | - | - |")
+}
+
+func TestList_Cache(t *testing.T) {
+	cfg := &config.Config{LLMEngines: map[string]config.LLMEngineConfig{
+		"mock":   {},
+		"broken": {Kind: "plugin", Command: []string{filepath.Join(t.TempDir(), "missing-plugin")}},
+	}}
+	cat, _ := testee.Bundled()
+	store, err := cache.NewModelStore(t.TempDir(), time.Hour)
+	assert.NoError(t, err)
+
+	// the broken engine is reported along with the listed one, in the order of the engine names
+	var streamed []string
+	listings := testee.List(context.Background(), "", cfg, cat, testee.ListOptions{Store: store, OnListing: func(listing testee.Listing) {
+		streamed = append(streamed, listing.Engine)
+	}})
+	assert.Equal(t, []string{"broken", "mock"}, streamed)
+	assert.Len(t, listings, 2)
+	assert.Equal(t, "broken", listings[0].Engine)
+	assert.Error(t, listings[0].Err)
+	assert.Equal(t, testee.SourceCatalog, listings[0].Source)
+	assert.Empty(t, listings[0].Models)
+	assert.Equal(t, "mock", listings[1].Engine)
+	assert.NoError(t, listings[1].Err)
+	assert.Equal(t, testee.SourceProvider, listings[1].Source)
+	assert.Len(t, listings[1].Models, len(llm.MockModels))
+
+	// a fresh listing is served by the cache unless refreshed
+	assert.NoError(t, cache.PutListing(store, "mock", []llm.ModelInfo{{ID: "cached", Listed: true}}))
+	listings = testee.List(context.Background(), "mock", cfg, cat, testee.ListOptions{Store: store})
+	assert.Equal(t, testee.SourceCache, listings[0].Source)
+	assert.Equal(t, "cached", listings[0].Models[0].ID)
+	listings = testee.List(context.Background(), "mock", cfg, cat, testee.ListOptions{Store: store, Refresh: true})
+	assert.Equal(t, testee.SourceProvider, listings[0].Source)
+	assert.Len(t, listings[0].Models, len(llm.MockModels))
+
+	// a stale listing is served if the provider fails
+	staleStore, err := cache.NewModelStore(t.TempDir(), time.Nanosecond)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.PutListing(staleStore, "broken", []llm.ModelInfo{{ID: "offline", Listed: true}}))
+	listings = testee.List(context.Background(), "broken", cfg, cat, testee.ListOptions{Store: staleStore})
+	assert.Error(t, listings[0].Err)
+	assert.Equal(t, testee.SourceCache, listings[0].Source)
+	assert.False(t, listings[0].FetchedAt.IsZero())
+	assert.Equal(t, "offline", listings[0].Models[0].ID)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"mock":   {},
+			"claude": {APIKey: "secret"},
+			"broken": {Kind: "plugin", Command: []string{filepath.Join(t.TempDir(), "missing-plugin")}},
+		},
+		Aliases: config.Aliases{"tiny": {Engine: "mock", Model: "mock-small"}},
+	}
+	cat, _ := testee.Bundled()
+	store, err := cache.NewModelStore(t.TempDir(), time.Hour)
+	assert.NoError(t, err)
+	opts := testee.ListOptions{Store: store}
+
+	assert.NoError(t, testee.Validate(context.Background(), "mock", "mock-small", cfg, cat, opts))
+	assert.NoError(t, testee.Validate(context.Background(), "MOCK", "Echo", cfg, cat, opts))
+	assert.NoError(t, testee.Validate(context.Background(), "mock", "", cfg, cat, opts))
+	assert.EqualError(t, testee.Validate(context.Background(), "unknown", "whatever", cfg, cat, opts), "unknown engine unknown")
+	assert.EqualError(t, testee.Validate(context.Background(), "mok", "", cfg, cat, opts), "unknown engine mok, did you mean mock?")
+
+	assert.EqualError(t, testee.Validate(context.Background(), "mock", "mock-smal", cfg, cat, opts), "unknown model mock-smal of mock, did you mean mock-small?")
+	assert.EqualError(t, testee.Validate(context.Background(), "mock", "mock", cfg, cat, opts),
+		"unknown model mock of mock, did you mean mock-large, mock-small?")
+	assert.EqualError(t, testee.Validate(context.Background(), "mock", "tinny", cfg, cat, opts), "unknown model tinny of mock, did you mean tiny?")
+	assert.EqualError(t, testee.Validate(context.Background(), "mock", "gpt-4o", cfg, cat, opts), "unknown model gpt-4o of mock")
+
+	// a fresh cached listing is used rather than the provider
+	assert.NoError(t, cache.PutListing(store, "mock", []llm.ModelInfo{{ID: "echo"}}))
+	assert.EqualError(t, testee.Validate(context.Background(), "mock", "mock-large", cfg, cat, opts), "unknown model mock-large of mock")
+
+	// a model missing from a stale cached listing is checked against the provider
+	staleStore, err := cache.NewModelStore(t.TempDir(), time.Nanosecond)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.PutListing(staleStore, "mock", []llm.ModelInfo{{ID: "echo"}}))
+	assert.NoError(t, testee.Validate(context.Background(), "mock", "mock-large", cfg, cat, testee.ListOptions{Store: staleStore}))
+
+	// the built-in models of claude are not listed by the provider
+	assert.NoError(t, testee.Validate(context.Background(), "claude", "claude-9", cfg, cat, opts))
+
+	// the models of the engine are not known for sure if the provider cannot be listed, and the failure is remembered
+	assert.NoError(t, testee.Validate(context.Background(), "broken", "anything", cfg, cat, opts))
+	failure := cache.GetListing[string](store, "broken-failure")
+	assert.NotNil(t, failure)
+	assert.NoError(t, testee.Validate(context.Background(), "broken", "anything", cfg, cat, opts))
+	assert.Equal(t, failure.FetchedAt, cache.GetListing[string](store, "broken-failure").FetchedAt)
+
+	// a model without a tag matches the latest one
+	assert.NoError(t, cache.PutListing(store, "mock", []llm.ModelInfo{{ID: "llama3:latest"}}))
+	assert.NoError(t, testee.Validate(context.Background(), "mock", "llama3", cfg, cat, opts))
+}
+
+func TestValidateEngine(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{"groq": {}, "ollama": {}},
+		Aliases:    config.Aliases{"fast": {Engine: "groq", Model: "llama3"}},
+	}
+	assert.NoError(t, testee.ValidateEngine("groq", cfg))
+	assert.NoError(t, testee.ValidateEngine(" Ollama ", cfg))
+	assert.NoError(t, testee.ValidateEngine("", cfg))
+	assert.EqualError(t, testee.ValidateEngine("grok", cfg), "unknown engine grok, did you mean groq?")
+	assert.EqualError(t, testee.ValidateEngine("nosuch", cfg), "unknown engine nosuch")
+}
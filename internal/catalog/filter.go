@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robinmin/askllm/internal/llm"
+)
+
+const (
+	CapabilityTools = "tools" // Supports tool calling
+	CapabilityJSON  = "json"  // Supports the JSON mode
+)
+
+// Filter selects the models by capabilities and context window
+type Filter struct {
+	Capabilities []string // tools, json, or an input modality such as image
+	MinContext   int      // Minimum context window in tokens, models of unknown context window excluded
+}
+
+// ParseCapabilities splits the comma-separated capabilities
+func ParseCapabilities(text string) []string {
+	var capabilities []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(strings.ToLower(item)); item != "" {
+			capabilities = append(capabilities, item)
+		}
+	}
+	return capabilities
+}
+
+// Match reports whether the model has all capabilities and a large enough context window
+func (f Filter) Match(model llm.ModelInfo) bool {
+	if f.MinContext > 0 && model.ContextWindow < f.MinContext {
+		return false
+	}
+	for _, capability := range f.Capabilities {
+		switch capability {
+		case CapabilityTools:
+			if !model.Tools {
+				return false
+			}
+		case CapabilityJSON:
+			if !model.JSONMode {
+				return false
+			}
+		default:
+			if !hasModality(model, capability) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply returns the models matching the filter
+func (f Filter) Apply(models []llm.ModelInfo) []llm.ModelInfo {
+	var result []llm.ModelInfo
+	for _, model := range models {
+		if f.Match(model) {
+			result = append(result, model)
+		}
+	}
+	return result
+}
+
+func hasModality(model llm.ModelInfo, modality string) bool {
+	for _, item := range model.Modalities {
+		if strings.EqualFold(item, modality) {
+			return true
+		}
+	}
+	return false
+}
+
+// Table renders the models as a markdown table. The default model of each engine, given by defaults, is
+// marked with [*].
+func Table(models []llm.ModelInfo, defaults map[string]string) string {
+	lines := []string{
+		"| Engine | Model | Description | Context | Max output | Modalities | Tools | JSON | Price in/out (USD/1M) |",
+		"| --- | --- | --- | --- | --- | --- | --- | --- | --- |",
+	}
+	for _, model := range models {
+		name := model.ID
+		if defaults[model.Engine] == model.ID {
+			name = "[*] " + name
+		}
+		description := model.Description
+		if model.Deprecated {
+			description = strings.TrimSpace(description + " (deprecated)")
+		}
+		price := "-"
+		if model.Pricing != nil {
+			price = fmt.Sprintf("%g / %g", model.Pricing.Input, model.Pricing.Output)
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s | %s | %s | %s | %s | %s |",
+			model.Engine, name, description, formatTokens(model.ContextWindow), formatTokens(model.MaxOutputTokens),
+			strings.Join(model.Modalities, ", "), formatBool(model.Tools), formatBool(model.JSONMode), price))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatTokens(tokens int) string {
+	switch {
+	case tokens <= 0:
+		return "-"
+	case tokens >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(tokens)/1_000_000)
+	case tokens >= 1000:
+		return fmt.Sprintf("%dK", tokens/1000)
+	default:
+		return fmt.Sprintf("%d", tokens)
+	}
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "-"
+}
@@ -0,0 +1,189 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/cache"
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// maxSuggestions is the maximum number of suggested models of an unknown one
+const maxSuggestions = 3
+
+// validateTimeout is the time limit of listing the models to validate a model against, as a query should not wait
+// long for the validation
+const validateTimeout = 3 * time.Second
+
+// failureTTL is how long a failed listing is remembered, during which the models are validated against the cache
+// or the catalog only rather than waiting for the provider again
+const failureTTL = 10 * time.Minute
+
+// ValidateEngine checks that the engine is configured, suggesting the closest configured engines and aliases if not.
+// An empty engine is left to resolve to the default one.
+func ValidateEngine(engine string, cfg *config.Config) error {
+	engineName := strings.TrimSpace(strings.ToLower(engine))
+	if _, ok := cfg.LLMEngines[engineName]; ok || engineName == "" {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(cfg.LLMEngines)+len(cfg.Aliases))
+	for name := range cfg.LLMEngines {
+		candidates = append(candidates, name)
+	}
+	for aliasName := range cfg.Aliases {
+		candidates = append(candidates, aliasName)
+	}
+	hint := ""
+	if suggestions := suggest(engineName, candidates); len(suggestions) > 0 {
+		hint = ", did you mean " + strings.Join(suggestions, ", ") + "?"
+	}
+	return fmt.Errorf("unknown engine %s%s", engine, hint)
+}
+
+// Validate checks that the engine is configured and the model is known by it, by its listing of the cache if fresh,
+// or else of the provider within a few seconds. An unknown model is an error suggesting the closest known models and
+// aliases. If the models are not listed by the provider itself, e.g. the built-in ones of claude, or the provider
+// cannot be listed, the model is only warned about, as the listing may be outdated. A failed listing is remembered
+// for a while in the cache, so that the following queries do not wait for the provider. An empty model is left to
+// the engine to resolve.
+func Validate(ctx context.Context, engine, model string, cfg *config.Config, cat *Catalog, opts ListOptions) error {
+	if err := ValidateEngine(engine, cfg); err != nil {
+		return err
+	}
+	engineName := strings.TrimSpace(strings.ToLower(engine))
+	model = strings.TrimSpace(model)
+	engineCfg, ok := cfg.LLMEngines[engineName]
+	if !ok || model == "" {
+		return nil
+	}
+
+	opts.OnListing = nil
+	if opts.Timeout <= 0 || opts.Timeout > validateTimeout {
+		opts.Timeout = validateTimeout
+	}
+	failureKey := engineName + "-failure"
+	if opts.Store != nil && !opts.Refresh {
+		if failure := cache.GetListing[string](opts.Store, failureKey); failure != nil && time.Since(failure.FetchedAt) <= failureTTL {
+			opts.Offline = true
+		}
+	}
+	listing := List(ctx, engineName, cfg, cat, opts)[0]
+	if listing.Err != nil && !opts.Offline && opts.Store != nil && ctx.Err() == nil {
+		if err := cache.PutListing(opts.Store, failureKey, []string{listing.Err.Error()}); err != nil {
+			log.Warnf("Failed to remember the failed listing of %s: %v", engineName, err)
+		}
+	}
+
+	ids := modelIDs(listing.Models)
+	if knownModel(model, ids) {
+		return nil
+	}
+
+	candidates := ids
+	for aliasName := range cfg.Aliases {
+		candidates = append(candidates, aliasName)
+	}
+	hint := ""
+	if suggestions := suggest(model, candidates); len(suggestions) > 0 {
+		hint = ", did you mean " + strings.Join(suggestions, ", ") + "?"
+	}
+
+	kind, _ := llm.LookupKind(llm.KindName(engineName, engineCfg))
+	if listing.Err != nil || listing.Source == SourceCatalog || !kind.Capabilities.ListModels {
+		// the models of the engine are not known for sure
+		if len(ids) > 0 {
+			log.Warnf("Model %s is not listed by %s%s", model, engineName, hint)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown model %s of %s%s", model, engineName, hint)
+}
+
+// knownModel reports whether the model is one of the ids, a model without a tag matching the latest one of ollama
+func knownModel(model string, ids []string) bool {
+	for _, id := range ids {
+		if strings.EqualFold(id, model) || strings.EqualFold(id, model+":latest") {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest returns the candidates closest to the name, containing it or within a few edits of it
+func suggest(name string, candidates []string) []string {
+	type scored struct {
+		candidate string
+		distance  int
+	}
+
+	name = strings.ToLower(name)
+	maxDistance := max(2, len(name)/3)
+	var matches []scored
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		lower := strings.ToLower(candidate)
+		if seen[lower] {
+			continue
+		}
+		seen[lower] = true
+
+		distance := levenshtein(name, lower)
+		if strings.Contains(lower, name) || strings.Contains(name, lower) {
+			distance = min(distance, 1)
+		}
+		if distance <= maxDistance {
+			matches = append(matches, scored{candidate, distance})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].candidate < matches[j].candidate
+	})
+	result := make([]string, 0, maxSuggestions)
+	for _, match := range matches {
+		if len(result) == maxSuggestions {
+			break
+		}
+		result = append(result, match.candidate)
+	}
+	return result
+}
+
+// levenshtein returns the number of single character edits to turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func modelIDs(models []llm.ModelInfo) []string {
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.ID)
+	}
+	return ids
+}
@@ -0,0 +1,266 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/output"
+	"github.com/robinmin/askllm/internal/tools"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+const helpText = `Available commands:
+  /engine <name> [model]  switch to another LLM engine
+  /model <name>           switch to another model of the current engine
+  /reset                  clear the conversation history
+  /save <file>            save the transcript as markdown
+  /help                   show this help
+  /exit                   quit the chat`
+
+// EngineFactory creates the LLM engine used by the chat
+type EngineFactory func(engineType, model string, cfg *config.Config) (llm.Engine, error)
+
+// REPL keeps the conversation history and sends the whole conversation to the engine on each turn
+type REPL struct {
+	cfg        *config.Config
+	engineName string
+	modelName  string
+	engine     llm.Engine
+	history    []llm.Message
+	newEngine  EngineFactory
+	options    []llm.CallOption
+	system     string
+	toolbox    *tools.Toolbox
+	in         io.Reader
+	out        io.Writer
+}
+
+func NewREPL(cfg *config.Config, engineName string, modelName string, in io.Reader, out io.Writer) *REPL {
+	return &REPL{
+		cfg:        cfg,
+		engineName: engineName,
+		modelName:  modelName,
+		newEngine:  llm.NewEngine,
+		in:         in,
+		out:        out,
+	}
+}
+
+// WithEngineFactory replaces the engine factory, mainly for testing purposes
+func (r *REPL) WithEngineFactory(factory EngineFactory) *REPL {
+	r.newEngine = factory
+	return r
+}
+
+// WithCallOptions sets the options applied on every turn
+func (r *REPL) WithCallOptions(options ...llm.CallOption) *REPL {
+	r.options = options
+	return r
+}
+
+// WithSystemPrompt sets the system prompt sent ahead of the conversation, kept on /reset
+func (r *REPL) WithSystemPrompt(system string) *REPL {
+	r.system = system
+	return r
+}
+
+// WithToolbox lets the model call the tools of the toolbox on every turn, none if nil
+func (r *REPL) WithToolbox(toolbox *tools.Toolbox) *REPL {
+	r.toolbox = toolbox
+	return r
+}
+
+// History returns the messages of the current conversation
+func (r *REPL) History() []llm.Message {
+	return r.history
+}
+
+// Run reads the user input line by line until EOF or /exit. A non-empty firstPrompt is sent as the first turn.
+// A turn is aborted on interrupt, or beyond the timeout of the config, and the chat goes on; an interrupt while
+// waiting for the input quits as usual.
+func (r *REPL) Run(ctx context.Context, firstPrompt string) error {
+	if err := r.switchEngine(r.engineName, r.modelName); err != nil {
+		return err
+	}
+	defer func() {
+		llm.CloseEngine(r.engine)
+	}()
+	r.printf("Chatting with %s/%s, type /help for available commands.\n", r.engineName, r.modelName)
+
+	if strings.TrimSpace(firstPrompt) != "" {
+		r.ask(ctx, firstPrompt)
+	}
+
+	scanner := bufio.NewScanner(r.in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		r.printf("> ")
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			quit, err := r.HandleCommand(line)
+			if err != nil {
+				r.printf("Error: %v\n", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+		r.ask(ctx, line)
+	}
+	r.printf("\n")
+	return scanner.Err()
+}
+
+// HandleCommand executes a slash command, and reports whether the chat should quit
+func (r *REPL) HandleCommand(line string) (bool, error) {
+	fields := strings.Fields(line)
+	args := fields[1:]
+
+	switch strings.ToLower(fields[0]) {
+	case "/exit", "/quit":
+		return true, nil
+	case "/help":
+		r.printf("%s\n", helpText)
+	case "/engine":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: /engine <name> [model]")
+		}
+		model := ""
+		if len(args) > 1 {
+			model = args[1]
+		}
+		if err := r.switchEngine(args[0], model); err != nil {
+			return false, err
+		}
+		r.printf("Switched to %s/%s\n", r.engineName, r.modelName)
+	case "/model":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: /model <name>")
+		}
+		if err := r.switchEngine(r.engineName, args[0]); err != nil {
+			return false, err
+		}
+		r.printf("Switched to %s/%s\n", r.engineName, r.modelName)
+	case "/reset":
+		r.history = nil
+		r.printf("Conversation history cleared\n")
+	case "/save":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: /save <file>")
+		}
+		if err := output.HandleOutput(args[0], r.Transcript(), output.FormatMarkdown); err != nil {
+			return false, err
+		}
+		r.printf("Transcript saved to %s\n", args[0])
+	default:
+		return false, fmt.Errorf("unknown command %s, type /help for available commands", fields[0])
+	}
+	return false, nil
+}
+
+// Transcript renders the conversation as markdown
+func (r *REPL) Transcript() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Chat transcript (%s)\n\n", time.Now().Format("2006-01-02 15:04:05")))
+	for _, msg := range r.history {
+		if msg.Role == llm.RoleTool {
+			continue
+		}
+		sb.WriteString("## " + strings.ToUpper(msg.Role[:1]) + msg.Role[1:] + "\n\n")
+		for _, call := range msg.ToolCalls {
+			sb.WriteString(fmt.Sprintf("> Called `%s` with `%s`\n\n", call.Function.Name, call.Function.Arguments))
+		}
+		if content := strings.TrimSpace(msg.Content); content != "" {
+			sb.WriteString(content + "\n\n")
+		}
+	}
+	return sb.String()
+}
+
+// switchEngine creates the engine of the model, resolving the model alias of the config if any, and falling back to
+// the default engine of the config and the default model of the engine as the engine factory does
+func (r *REPL) switchEngine(engineName string, modelName string) error {
+	engineName, modelName = r.cfg.Aliases.Resolve(engineName, modelName)
+	engineName = strings.TrimSpace(strings.ToLower(engineName))
+	if engineName == "" {
+		engineName = r.cfg.Sys.DefaultEngine
+	}
+	if _, ok := r.cfg.LLMEngines[engineName]; !ok {
+		return fmt.Errorf("unknown LLM engine: %s", engineName)
+	}
+	engineName, modelName = llm.ResolveEngine(engineName, modelName, r.cfg)
+
+	engine, err := r.newEngine(engineName, modelName, r.cfg)
+	if err != nil {
+		return err
+	}
+	if r.engine != nil {
+		llm.CloseEngine(r.engine)
+	}
+	r.engine = engine
+	r.engineName = engineName
+	r.modelName = modelName
+	return nil
+}
+
+func (r *REPL) ask(ctx context.Context, prompt string) {
+	r.history = append(r.history, llm.Message{Role: llm.RoleUser, Content: prompt})
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	if r.cfg.Sys.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.Sys.Timeout)
+		defer cancel()
+	}
+
+	sw := output.NewStreamWriterFor(r.out)
+	options := append([]llm.CallOption{llm.WithContext(ctx)}, r.options...)
+	var response *llm.Response
+	var exchange []llm.Message // the tool calls and results are kept in the history along with the answer
+	var err error
+	if r.toolbox != nil {
+		response, exchange, err = tools.Run(r.engine, llm.PrependSystem(r.system, r.history), r.toolbox, sw.Write, options...)
+	} else {
+		response, err = r.engine.ChatStream(llm.PrependSystem(r.system, r.history), sw.Write, options...)
+	}
+	if err != nil {
+		log.Error("Error querying LLM: " + err.Error())
+		if errors.Is(err, context.Canceled) {
+			r.printf("\nInterrupted\n")
+		} else {
+			r.printf("\nError: %v\n", err)
+		}
+		// drop the failed turn so that it can be retried
+		r.history = r.history[:len(r.history)-1]
+		return
+	}
+	if err := sw.Finish(response.Content); err != nil {
+		log.Error("Error handling output: " + err.Error())
+	}
+	if len(exchange) == 0 {
+		exchange = []llm.Message{{Role: llm.RoleAssistant, Content: response.Content}}
+	}
+	r.history = append(r.history, exchange...)
+}
+
+func (r *REPL) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(r.out, format, args...)
+}
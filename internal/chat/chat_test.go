@@ -0,0 +1,190 @@
+package chat_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	testee "github.com/robinmin/askllm/internal/chat"
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+	"github.com/robinmin/askllm/internal/tools"
+)
+
+// echoEngine answers with its name and the last message
+func echoEngine(name string) *llmtest.Engine {
+	return llmtest.New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+		last := messages[len(messages)-1].Content
+		if last == "fail" {
+			return nil, fmt.Errorf("provider is down")
+		}
+		result := fmt.Sprintf("%s says %s", name, last)
+		return &llm.Response{Content: result, Usage: llm.EstimateUsage(messages, result)}, nil
+	})
+}
+
+func newTestREPL(input string) (*testee.REPL, map[string]*llmtest.Engine, *bytes.Buffer) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"ollama": {},
+			"groq":   {},
+		},
+	}
+	cfg.Sys.DefaultEngine = "ollama"
+
+	engines := map[string]*llmtest.Engine{}
+	out := &bytes.Buffer{}
+	repl := testee.NewREPL(cfg, "", "", strings.NewReader(input), out).
+		WithEngineFactory(func(engineType, model string, cfg *config.Config) (llm.Engine, error) {
+			name := engineType + "/" + model
+			engines[name] = echoEngine(name)
+			return engines[name], nil
+		})
+	return repl, engines, out
+}
+
+func TestREPL_Run(t *testing.T) {
+	t.Run("KeepsHistory", func(t *testing.T) {
+		repl, engines, _ := newTestREPL("second\n\nthird\n")
+		assert.NoError(t, repl.Run(context.Background(), "first"))
+
+		history := repl.History()
+		assert.Len(t, history, 6)
+		assert.Equal(t, llm.RoleUser, history[4].Role)
+		assert.Equal(t, "third", history[4].Content)
+		assert.Equal(t, llm.RoleAssistant, history[5].Role)
+		assert.Equal(t, "ollama/gemma2 says third", history[5].Content)
+
+		// the whole conversation is sent on each turn
+		calls := engines["ollama/gemma2"].Calls()
+		assert.Len(t, calls, 3)
+		assert.Len(t, calls[2].Messages, 5)
+	})
+
+	t.Run("DropsFailedTurn", func(t *testing.T) {
+		repl, _, out := newTestREPL("fail\nhello\n")
+		assert.NoError(t, repl.Run(context.Background(), ""))
+		assert.Len(t, repl.History(), 2)
+		assert.Contains(t, out.String(), "provider is down")
+	})
+
+	t.Run("Exit", func(t *testing.T) {
+		repl, _, _ := newTestREPL("/exit\nhello\n")
+		assert.NoError(t, repl.Run(context.Background(), ""))
+		assert.Empty(t, repl.History())
+	})
+
+	t.Run("UnknownEngine", func(t *testing.T) {
+		repl, _, _ := newTestREPL("")
+		repl = repl.WithEngineFactory(nil)
+		_, err := repl.HandleCommand("/engine nothing")
+		assert.Error(t, err)
+	})
+}
+
+func TestREPL_SystemPrompt(t *testing.T) {
+	repl, engines, _ := newTestREPL("hello\n/reset\nagain\n")
+	repl = repl.WithSystemPrompt("be brief")
+	assert.NoError(t, repl.Run(context.Background(), ""))
+
+	// the system prompt is sent on each turn but kept out of the history
+	calls := engines["ollama/gemma2"].Calls()
+	assert.Len(t, calls, 2)
+	for _, call := range calls {
+		assert.Equal(t, llm.Message{Role: llm.RoleSystem, Content: "be brief"}, call.Messages[0])
+	}
+	assert.Len(t, calls[1].Messages, 2)
+	assert.Len(t, repl.History(), 2)
+}
+
+func TestREPL_HandleCommand(t *testing.T) {
+	repl, engines, out := newTestREPL("hello\n/engine groq llama3\nagain\n/model mixtral\n/reset\n/unknown\n")
+	assert.NoError(t, repl.Run(context.Background(), ""))
+
+	assert.Contains(t, out.String(), "Switched to groq/llama3")
+	assert.Contains(t, out.String(), "Switched to groq/mixtral")
+	assert.Contains(t, out.String(), "unknown command /unknown")
+	assert.Empty(t, repl.History())
+
+	// the history survives switching engines
+	assert.Len(t, engines["ollama/gemma2"].Calls(), 1)
+	assert.Len(t, engines["groq/llama3"].Calls(), 1)
+	assert.Len(t, engines["groq/llama3"].LastCall().Messages, 3)
+}
+
+func TestREPL_SwitchEngine(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"ollama":   {},
+			"groq":     {},
+			"deepseek": {Kind: "openai", Model: "deepseek-chat"},
+		},
+		Aliases: config.Aliases{"fast": {Engine: "groq", Model: "llama3-8b"}},
+	}
+	cfg.Sys.DefaultEngine = "ollama"
+
+	var created []string
+	out := &bytes.Buffer{}
+	repl := testee.NewREPL(cfg, "", "", strings.NewReader("/engine deepseek\n/model fast\n/engine ollama\n"), out).
+		WithEngineFactory(func(engineType, model string, cfg *config.Config) (llm.Engine, error) {
+			created = append(created, engineType+"/"+model)
+			return echoEngine(engineType + "/" + model), nil
+		})
+	assert.NoError(t, repl.Run(context.Background(), ""))
+
+	// the configured model of an engine declared by the config only, then the engine and model of the alias
+	assert.Contains(t, out.String(), "Switched to deepseek/deepseek-chat")
+	assert.Contains(t, out.String(), "Switched to groq/llama3-8b")
+	assert.Contains(t, out.String(), "Switched to ollama/gemma2")
+	assert.Equal(t, []string{"ollama/gemma2", "deepseek/deepseek-chat", "groq/llama3-8b", "ollama/gemma2"}, created)
+}
+
+func TestREPL_Save(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "transcript.md")
+	repl, _, _ := newTestREPL("hello\n/save " + file + "\n")
+	assert.NoError(t, repl.Run(context.Background(), ""))
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "## User\n\nhello")
+	assert.Contains(t, string(data), "## Assistant\n\nollama/gemma2 says hello")
+}
+
+func TestREPL_Tools(t *testing.T) {
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "fixture.yaml")
+	assert.NoError(t, os.WriteFile(fixture, []byte(`responses:
+  - match: "files"
+    response: "There is a fixture"
+    tool_calls:
+      - name: list_directory
+`), 0o644))
+
+	cfg := &config.Config{LLMEngines: map[string]config.LLMEngineConfig{"mock": {Fixture: fixture}}}
+	cfg.Tools.Approved = []string{tools.ListDirectory}
+	toolbox, err := tools.NewToolbox(cfg, dir, nil)
+	assert.NoError(t, err)
+
+	file := filepath.Join(dir, "transcript.md")
+	out := &bytes.Buffer{}
+	repl := testee.NewREPL(cfg, "mock", "echo", strings.NewReader("list the files\n/save "+file+"\n"), out).WithToolbox(toolbox)
+	assert.NoError(t, repl.Run(context.Background(), ""))
+
+	history := repl.History()
+	assert.Len(t, history, 4)
+	assert.Equal(t, llm.Message{Role: llm.RoleTool, Content: "fixture.yaml\n", ToolCallID: "call_1"}, history[2])
+	assert.Equal(t, llm.Message{Role: llm.RoleAssistant, Content: "There is a fixture"}, history[3])
+	assert.Contains(t, out.String(), "There is a fixture")
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "## Assistant\n\n> Called `list_directory` with `{}`\n\n## Assistant\n\nThere is a fixture")
+	assert.NotContains(t, string(data), "## Tool")
+}
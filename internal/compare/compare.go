@@ -0,0 +1,183 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// EngineFactory creates the LLM engine of each target
+type EngineFactory func(engineType, model string, cfg *config.Config) (llm.Engine, error)
+
+// Target is an engine and model pair to run the prompt against
+type Target struct {
+	Engine string
+	Model  string
+}
+
+func (t Target) String() string {
+	return t.Engine + "/" + t.Model
+}
+
+// Result is the outcome of running the prompt against a single target
+type Result struct {
+	Target   Target
+	Response string
+	Latency  time.Duration
+	Usage    llm.Usage
+	Err      error
+}
+
+// IsCompareSpec reports whether the engine flag lists targets to compare, i.e. more than one target or an
+// engine:model pair
+func IsCompareSpec(spec string) bool {
+	return strings.ContainsAny(spec, ",:")
+}
+
+// ParseTargets parses a comma-separated list of engine:model pairs. The model is optional.
+func ParseTargets(spec string, defaultModel func(engine string) string) ([]Target, error) {
+	var targets []Target
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		engine, model, _ := strings.Cut(item, ":")
+		engine = strings.ToLower(strings.TrimSpace(engine))
+		model = strings.TrimSpace(model)
+		if engine == "" {
+			return nil, fmt.Errorf("missing engine name in %q", item)
+		}
+		if model == "" && defaultModel != nil {
+			model = defaultModel(engine)
+		}
+		targets = append(targets, Target{Engine: engine, Model: model})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no engine specified in %q", spec)
+	}
+	return targets, nil
+}
+
+// Run sends the messages to all targets concurrently. The results keep the order of the targets.
+func Run(targets []Target, messages []llm.Message, cfg *config.Config, newEngine EngineFactory, options ...llm.CallOption) []Result {
+	results := make([]Result, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+
+			result := Result{Target: target}
+			if _, ok := cfg.LLMEngines[target.Engine]; !ok {
+				result.Err = fmt.Errorf("unknown LLM engine: %s", target.Engine)
+				results[i] = result
+				return
+			}
+
+			startTime := time.Now()
+			engine, err := newEngine(target.Engine, target.Model, cfg)
+			var response *llm.Response
+			if err == nil {
+				response, err = llm.Chat(engine, messages, options...)
+				llm.CloseEngine(engine)
+			}
+			result.Latency = time.Since(startTime)
+			result.Err = err
+			if err != nil {
+				log.Errorf("Error querying %s: %v", target, err)
+			} else {
+				result.Response = response.Content
+				result.Usage = response.Usage
+				log.Infof("Got response from %s in %s", target, result.Latency)
+			}
+			results[i] = result
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// Report renders the results side by side as a markdown table, with a column per target. As a table cell holds a
+// single line, the lines of a response are joined by <br>.
+func Report(prompt string, results []Result) string {
+	var sb strings.Builder
+	sb.WriteString("# Comparison report\n\n")
+	sb.WriteString("## Prompt\n\n" + strings.TrimSpace(prompt) + "\n\n")
+
+	rows := [][]string{{""}, {"---"}, {"Latency"}, {"Prompt tokens"}, {"Completion tokens"}, {"Status"}, {"Response"}}
+	for _, result := range results {
+		status := "OK"
+		response := strings.TrimSpace(result.Response)
+		if result.Err != nil {
+			status = "Error"
+			response = "**Error:** " + result.Err.Error()
+		}
+		// estimated counts are marked with a tilde
+		mark := ""
+		if result.Usage.Estimated {
+			mark = "~"
+		}
+		rows[0] = append(rows[0], result.Target.String())
+		rows[1] = append(rows[1], "---")
+		rows[2] = append(rows[2], result.Latency.Round(time.Millisecond).String())
+		rows[3] = append(rows[3], fmt.Sprintf("%s%d", mark, result.Usage.PromptTokens))
+		rows[4] = append(rows[4], fmt.Sprintf("%s%d", mark, result.Usage.CompletionTokens))
+		rows[5] = append(rows[5], status)
+		rows[6] = append(rows[6], tableCell(response))
+	}
+	for _, row := range rows {
+		sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+	}
+	return sb.String()
+}
+
+// tableCell turns the text into a single line of a markdown table cell
+func tableCell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.Join(strings.Fields(strings.ReplaceAll(text, "\n", " <br> ")), " ")
+}
+
+// resultDocument is the result of a target in the JSON document of the results
+type resultDocument struct {
+	Engine    string    `json:"engine"`
+	Model     string    `json:"model"`
+	Response  string    `json:"response,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	Usage     llm.Usage `json:"usage"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Document renders the results as a JSON document, to be formatted as JSON or YAML
+func Document(prompt string, results []Result) (string, error) {
+	doc := struct {
+		Prompt  string           `json:"prompt"`
+		Results []resultDocument `json:"results"`
+	}{Prompt: prompt, Results: make([]resultDocument, 0, len(results))}
+	for _, result := range results {
+		item := resultDocument{
+			Engine:    result.Target.Engine,
+			Model:     result.Target.Model,
+			Response:  result.Response,
+			LatencyMS: result.Latency.Milliseconds(),
+			Usage:     result.Usage,
+		}
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		}
+		doc.Results = append(doc.Results, item)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the comparison: %v", err)
+	}
+	return string(data), nil
+}
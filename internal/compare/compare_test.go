@@ -0,0 +1,123 @@
+package compare_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	testee "github.com/robinmin/askllm/internal/compare"
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+)
+
+func TestParseTargets(t *testing.T) {
+	defaultModel := func(engine string) string { return "default-" + engine }
+
+	tests := []struct {
+		name     string
+		spec     string
+		expected []testee.Target
+		hasError bool
+	}{
+		{
+			"EngineAndModel",
+			"chatgpt:gpt-4o, Claude:claude-3-haiku-20240307",
+			[]testee.Target{{Engine: "chatgpt", Model: "gpt-4o"}, {Engine: "claude", Model: "claude-3-haiku-20240307"}},
+			false,
+		},
+		{
+			"DefaultModel",
+			"ollama,groq:gemma2-9b-it",
+			[]testee.Target{{Engine: "ollama", Model: "default-ollama"}, {Engine: "groq", Model: "gemma2-9b-it"}},
+			false,
+		},
+		{"ModelWithColon", "ollama:llama3:8b,groq", []testee.Target{{Engine: "ollama", Model: "llama3:8b"}, {Engine: "groq", Model: "default-groq"}}, false},
+		{"MissingEngine", ":gpt-4o,ollama", nil, true},
+		{"Empty", ",", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := testee.ParseTargets(tt.spec, defaultModel)
+			if tt.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, targets)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{"ollama": {}, "groq": {}},
+	}
+	targets := []testee.Target{
+		{Engine: "ollama", Model: "gemma2"},
+		{Engine: "groq", Model: "broken"},
+		{Engine: "unknown", Model: "x"},
+		{Engine: "groq", Model: "llama3"},
+	}
+	factory := func(engineType, model string, cfg *config.Config) (llm.Engine, error) {
+		name := engineType + "/" + model
+		return llmtest.New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+			time.Sleep(50 * time.Millisecond)
+			if name == "groq/broken" {
+				return nil, fmt.Errorf("rate limited")
+			}
+			// ollama does not report the usage
+			content := "answer from " + name
+			if name == "ollama/gemma2" {
+				return &llm.Response{Content: content, Usage: llm.EstimateUsage(messages, content)}, nil
+			}
+			return &llm.Response{Content: content, Usage: llm.Usage{PromptTokens: 10, CompletionTokens: 20}}, nil
+		}), nil
+	}
+
+	startTime := time.Now()
+	results := testee.Run(targets, []llm.Message{{Role: llm.RoleUser, Content: "hello"}}, cfg, factory)
+	// all targets run concurrently
+	assert.Less(t, time.Since(startTime), 150*time.Millisecond)
+
+	assert.Len(t, results, 4)
+	assert.Equal(t, "answer from ollama/gemma2", results[0].Response)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 2, results[0].Usage.PromptTokens)
+	assert.Positive(t, results[0].Usage.CompletionTokens)
+	assert.True(t, results[0].Usage.Estimated)
+	assert.EqualError(t, results[1].Err, "rate limited")
+	assert.Error(t, results[2].Err)
+	assert.Equal(t, "answer from groq/llama3", results[3].Response)
+	assert.Equal(t, llm.Usage{PromptTokens: 10, CompletionTokens: 20}, results[3].Usage)
+
+	report := testee.Report("hello", results)
+	assert.Contains(t, report, "|  | ollama/gemma2 | groq/broken | unknown/x | groq/llama3 |\n| --- | --- | --- | --- | --- |\n")
+	assert.Contains(t, report, "| Prompt tokens | ~2 | 0 | 0 | 10 |\n")
+	assert.Contains(t, report, "| Status | OK | Error | Error | OK |\n")
+	assert.Contains(t, report, "| **Error:** rate limited |")
+	assert.Contains(t, report, "| answer from groq/llama3 |\n")
+
+	doc, err := testee.Document("hello", results)
+	assert.NoError(t, err)
+	assert.Contains(t, doc, `{"engine":"groq","model":"broken","latency_ms":`)
+	assert.Contains(t, doc, `"error":"rate limited"}`)
+	assert.Contains(t, doc, `"response":"answer from groq/llama3","latency_ms":`)
+}
+
+func TestIsCompareSpec(t *testing.T) {
+	assert.True(t, testee.IsCompareSpec("chatgpt,ollama"))
+	assert.True(t, testee.IsCompareSpec("groq:llama3"))
+	assert.False(t, testee.IsCompareSpec("groq"))
+	assert.False(t, testee.IsCompareSpec(""))
+}
+
+func TestReport_MultilineResponse(t *testing.T) {
+	report := testee.Report("hello", []testee.Result{
+		{Target: testee.Target{Engine: "groq", Model: "llama3"}, Response: "first line\n\n| a | b |\nlast line\n"},
+	})
+	assert.Contains(t, report, "| Response | first line <br> <br> \\| a \\| b \\| <br> last line |\n")
+}
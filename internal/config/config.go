@@ -3,35 +3,170 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/robinmin/askllm/pkg/utils"
 )
 
 const (
 	VERSION = "0.1.8"
+
+	DefaultSessionPath = "~/.askllm/sessions"
+	DefaultCatalogPath = "~/.askllm/catalog.yaml"
+	DefaultListTimeout = 30 * time.Second
+
+	DefaultCachePath    = "~/.askllm/cache"
+	DefaultCacheTTL     = 24 * time.Hour
+	DefaultCacheMaxSize = 64 << 20
+	DefaultModelsTTL    = 24 * time.Hour
+
+	DefaultToolSteps  = 10
+	DefaultToolOutput = 32 << 10
 )
 
 type Config struct {
 	Sys struct {
-		LogPath       string `yaml:"log_path,omitempty"`
-		LogLevel      string `yaml:"log_level,omitempty"`
-		DefaultEngine string `yaml:"default_engine,omitempty"` // Default LLM engine to use
+		LogPath       string        `yaml:"log_path,omitempty"`
+		LogLevel      string        `yaml:"log_level,omitempty"`
+		DefaultEngine string        `yaml:"default_engine,omitempty"` // Default LLM engine to use
+		SessionPath   string        `yaml:"session_path,omitempty"`   // Folder to store the conversation sessions
+		MaxInputSize  int64         `yaml:"max_input_size,omitempty"` // Size limit in bytes of the piped input
+		CatalogPath   string        `yaml:"catalog_path,omitempty"`   // Model catalog overriding the bundled one
+		ListTimeout   time.Duration `yaml:"list_timeout,omitempty"`   // Time limit for an engine to list its models, e.g. 30s
+		Timeout       time.Duration `yaml:"timeout,omitempty"`        // Time limit of a run, a chat turn or a server request, including the prompt URLs
 	} `yaml:"sys"`
+	Server struct {
+		Addr   string `yaml:"addr,omitempty"`    // Listen address of the OpenAI-compatible gateway
+		APIKey string `yaml:"api_key,omitempty"` // Optional bearer token required from clients
+	} `yaml:"server"`
+	Cache struct {
+		Disabled  bool          `yaml:"disabled,omitempty"`   // Disable the response cache
+		Path      string        `yaml:"path,omitempty"`       // Folder to store the cached responses
+		TTL       time.Duration `yaml:"ttl,omitempty"`        // Time to live of a cached response, e.g. 24h
+		MaxSize   int64         `yaml:"max_size,omitempty"`   // Size limit in bytes, the least recently used responses are evicted beyond it
+		ModelsTTL time.Duration `yaml:"models_ttl,omitempty"` // Time to live of a cached model listing, e.g. 24h
+	} `yaml:"cache"`
+	Tools struct {
+		Approved  []string `yaml:"approved,omitempty"`   // Tools run without confirmation, e.g. read_file
+		Commands  []string `yaml:"commands,omitempty"`   // Commands allowed to run, matched by their leading words, e.g. go test
+		MaxSteps  int      `yaml:"max_steps,omitempty"`  // Maximum number of tool calling rounds of a query
+		MaxOutput int      `yaml:"max_output,omitempty"` // Size limit in bytes of a tool result sent to the model
+	} `yaml:"tools"`
 	LLMEngines map[string]LLMEngineConfig `yaml:"llm_engines"`
+	Pricing    map[string]ModelPrice      `yaml:"pricing,omitempty"` // Price of each model, keyed by engine/model or model
+	Aliases    Aliases                    `yaml:"aliases,omitempty"` // Short names of engine and model pairs, e.g. fast or smart
+}
+
+// ModelAlias is the engine and model named by an alias
+type ModelAlias struct {
+	Engine string `yaml:"engine,omitempty"` // Engine of the model, the given one if empty
+	Model  string `yaml:"model"`
+}
+
+// Aliases are the model aliases keyed by their case-insensitive names
+type Aliases map[string]ModelAlias
+
+// Lookup returns the alias of the name
+func (a Aliases) Lookup(name string) (ModelAlias, bool) {
+	name = strings.TrimSpace(name)
+	for aliasName, alias := range a {
+		if strings.EqualFold(aliasName, name) {
+			return alias, true
+		}
+	}
+	return ModelAlias{}, false
+}
+
+// Resolve returns the engine and model of the alias given as the model, the engine of the alias taking precedence
+// over the given one, or the given engine and model if the model is no alias
+func (a Aliases) Resolve(engine, model string) (string, string) {
+	alias, ok := a.Lookup(model)
+	if !ok {
+		return engine, model
+	}
+	if alias.Engine != "" {
+		engine = alias.Engine
+	}
+	return engine, alias.Model
+}
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Input  float64 `yaml:"input"`  // Price per million prompt tokens
+	Output float64 `yaml:"output"` // Price per million completion tokens
 }
 
+// Cost calculates the cost in USD of the given number of tokens
+func (p ModelPrice) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1_000_000
+}
+
+// Price looks up the price of the model, the engine/model key taking precedence over the model key
+func (c *Config) Price(engine, model string) (ModelPrice, bool) {
+	if price, ok := c.Pricing[engine+"/"+model]; ok {
+		return price, true
+	}
+	price, ok := c.Pricing[model]
+	return price, ok
+}
+
+// LLMEngineConfig is the config of a named engine. Fixture and Latency are only available for mock, Command for plugin,
+// and the fields from ChatPath to QueryParams for openai and groq.
 type LLMEngineConfig struct {
-	APIKey        string `yaml:"api_key"`
-	Model         string `yaml:"model"`
-	BaseURL       string `yaml:"base_url,omitempty"`
-	OrgnizationId string `yaml:"organization_id,omitempty"` // So far, only avaliable for chatgpt and groq
-	ExtraKey      string `yaml:"extra_key,omitempty"`       // So far, only avaliable for gemini
-	ExtraURL      string `yaml:"extra_url,omitempty"`       // So far, only avaliable for gemini, ollama
+	Kind             string            `yaml:"kind,omitempty"` // Kind of the engine, e.g. chatgpt for an OpenAI-compatible endpoint; the engine name by default
+	APIKey           string            `yaml:"api_key"`
+	Model            string            `yaml:"model"`
+	BaseURL          string            `yaml:"base_url,omitempty"`
+	OrgnizationId    string            `yaml:"organization_id,omitempty"` // So far, only avaliable for chatgpt and groq
+	ExtraKey         string            `yaml:"extra_key,omitempty"`       // So far, only avaliable for gemini
+	ExtraURL         string            `yaml:"extra_url,omitempty"`       // So far, only avaliable for gemini, ollama
+	Fallback         []string          `yaml:"fallback,omitempty"`        // Engines to try in order on failure, as engine or engine:model
+	Timeout          time.Duration     `yaml:"timeout,omitempty"`         // Time limit of a query, e.g. 2m; none by default
+	Fixture          string            `yaml:"fixture,omitempty"`         // File of canned responses
+	Latency          time.Duration     `yaml:"latency,omitempty"`         // Simulated delay of a response
+	Command          []string          `yaml:"command,omitempty"`         // Executable and its arguments
+	ChatPath         string            `yaml:"chat_path,omitempty"`       // /chat/completions by default
+	ModelsPath       string            `yaml:"models_path,omitempty"`     // /models by default
+	AuthHeader       string            `yaml:"auth_header,omitempty"`     // Authorization by default
+	AuthScheme       string            `yaml:"auth_scheme,omitempty"`     // Bearer by default for the Authorization header
+	Headers          map[string]string `yaml:"headers,omitempty"`         // Extra headers of every request
+	QueryParams      map[string]string `yaml:"query_params,omitempty"`    // Query parameters of every request, e.g. api-version
+	GenerationParams `yaml:",inline"`
+}
+
+// GenerationParams are the sampling parameters of a query. A nil value means not set.
+type GenerationParams struct {
+	Temperature *float64 `yaml:"temperature,omitempty" json:"temperature,omitempty"` // Sampling temperature
+	TopP        *float64 `yaml:"top_p,omitempty" json:"top_p,omitempty"`             // Nucleus sampling probability mass
+	MaxTokens   *int     `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"`   // Maximum number of tokens to generate
+	Stop        []string `yaml:"stop,omitempty" json:"stop,omitempty"`               // Sequences to stop the generation at
+	Seed        *int     `yaml:"seed,omitempty" json:"seed,omitempty"`               // Seed for deterministic sampling, if supported
+}
+
+// Merge returns a copy of the parameters overridden by the ones set in override
+func (p GenerationParams) Merge(override GenerationParams) GenerationParams {
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		p.MaxTokens = override.MaxTokens
+	}
+	if len(override.Stop) > 0 {
+		p.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		p.Seed = override.Seed
+	}
+	return p
 }
 
 func Load(filename string) (*Config, error) {
 	// Expand the tilde to the user's home directory
-	absolutePath, err := expandTilde(filename)
+	absolutePath, err := ExpandTilde(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +174,8 @@ func Load(filename string) (*Config, error) {
 	return utils.LoadConfig[Config](absolutePath)
 }
 
-func expandTilde(path string) (string, error) {
+// ExpandTilde expands the leading tilde of the path to the user's home directory
+func ExpandTilde(path string) (string, error) {
 	if len(path) == 0 || path[0] != '~' {
 		return path, nil // Path doesn't start with '~', return as is
 	}
@@ -132,3 +132,34 @@ llm_engines:
     # base_url: http://127.0.0.1:11434
 `
 }
+
+func TestAliases_Resolve(t *testing.T) {
+	aliases := Aliases{
+		"Sonnet": {Engine: "claude", Model: "claude-3-sonnet-20240229"},
+		"local":  {Model: "llama3.1"},
+	}
+
+	tests := []struct {
+		name, engine, model         string
+		expectEngine, expectedModel string
+	}{
+		{"Alias", "", "sonnet", "claude", "claude-3-sonnet-20240229"},
+		{"AliasOverridesEngine", "groq", " SONNET ", "claude", "claude-3-sonnet-20240229"},
+		{"AliasWithoutEngine", "ollama", "local", "ollama", "llama3.1"},
+		{"NoAlias", "groq", "llama3-8b", "groq", "llama3-8b"},
+		{"Empty", "groq", "", "groq", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, model := aliases.Resolve(tt.engine, tt.model)
+			if engine != tt.expectEngine || model != tt.expectedModel {
+				t.Errorf("Resolve(%q, %q) = %q, %q; want %q, %q", tt.engine, tt.model, engine, model, tt.expectEngine, tt.expectedModel)
+			}
+		})
+	}
+
+	var none Aliases
+	if engine, model := none.Resolve("groq", "fast"); engine != "groq" || model != "fast" {
+		t.Errorf("Resolve without aliases = %q, %q", engine, model)
+	}
+}
@@ -3,6 +3,7 @@ package llm
 import (
 	"context"
 	"fmt"
+	"time"
 
 	// "os"
 
@@ -16,18 +17,27 @@ import (
 type ChatGPT struct {
 	model    string
 	llm      llms.Model
-	context  context.Context
+	timeout  time.Duration // Time limit of a query, none if zero
 	chatURL  string
 	modelURL string
-	models   []string // List of all available models
-	apiKey   string   // API token
+	models   []string                // List of all available models
+	apiKey   string                  // API token
+	params   config.GenerationParams // Generation parameters of the engine config
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "chatgpt",
+		DefaultModel: "gpt-4o-mini",
+		Capabilities: Capabilities{JSONMode: true, ListModels: true, Tools: true},
+		New:          constructor(NewChatGPT),
+	})
 }
 
 func NewChatGPT(model string, cfg config.LLMEngineConfig) (*ChatGPT, error) {
 	var llm llms.Model
 	var err error
 
-	ctx := context.Background()
 	if model == "" {
 		model = cfg.Model
 	}
@@ -38,19 +48,21 @@ func NewChatGPT(model string, cfg config.LLMEngineConfig) (*ChatGPT, error) {
 				openai.WithModel(model),
 				openai.WithOrganization(cfg.OrgnizationId),
 				openai.WithBaseURL(cfg.BaseURL),
+				openai.WithHTTPClient(utils.HTTPClient()),
 			)
 		} else {
 			llm, err = openai.New(
 				openai.WithToken(cfg.APIKey),
 				openai.WithModel(model),
 				openai.WithOrganization(cfg.OrgnizationId),
+				openai.WithHTTPClient(utils.HTTPClient()),
 			)
 		}
 	} else {
 		if cfg.BaseURL != "" {
-			llm, err = openai.New(openai.WithToken(cfg.APIKey), openai.WithModel(model), openai.WithBaseURL(cfg.BaseURL))
+			llm, err = openai.New(openai.WithToken(cfg.APIKey), openai.WithModel(model), openai.WithBaseURL(cfg.BaseURL), openai.WithHTTPClient(utils.HTTPClient()))
 		} else {
-			llm, err = openai.New(openai.WithToken(cfg.APIKey), openai.WithModel(model))
+			llm, err = openai.New(openai.WithToken(cfg.APIKey), openai.WithModel(model), openai.WithHTTPClient(utils.HTTPClient()))
 		}
 	}
 	if err != nil {
@@ -60,21 +72,24 @@ func NewChatGPT(model string, cfg config.LLMEngineConfig) (*ChatGPT, error) {
 	return &ChatGPT{
 		model:    model,
 		llm:      llm,
-		context:  ctx,
+		timeout:  cfg.Timeout,
 		chatURL:  cfg.BaseURL + "/chat/completions",
 		modelURL: cfg.BaseURL + "/models",
 		apiKey:   cfg.APIKey,
+		params:   cfg.GenerationParams,
 	}, nil
 }
 
-func (c *ChatGPT) Query(prompt string) (string, error) {
-	result, err := llms.GenerateFromSinglePrompt(
-		c.context, c.llm, prompt,
-		llms.WithTemperature(0.2),
+func (c *ChatGPT) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	ctx, cancel := callOpts.contextWithin(c.timeout)
+	defer cancel()
+	result, err := generateContent(
+		ctx, c.llm, messages, onChunk, callOpts, c.params,
 		llms.WithModel(c.model),
 	)
 	if err != nil {
-		return "", fmt.Errorf("ChatGPT query failed: %v", err)
+		return nil, fmt.Errorf("ChatGPT query failed: %w", err)
 	}
 	return result, nil
 }
@@ -123,13 +138,13 @@ type ChatGPTModelsListResponse struct {
 	Object ObjectType
 }
 
-func (c *ChatGPT) ListAllModelsCore() ([]ChatGPTModel, error) {
+func (c *ChatGPT) ListAllModelsCore(ctx context.Context) ([]ChatGPTModel, error) {
 	headers := map[string]string{
 		"Authorization": "Bearer " + c.apiKey,
 		"Content-Type":  "application/json",
 	}
 
-	response, err := utils.APIGet[ChatGPTModelsListResponse](c.modelURL, headers)
+	response, err := utils.APIGet[ChatGPTModelsListResponse](ctx, c.modelURL, headers)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching ChatGPT models: %v", err)
 	}
@@ -151,13 +166,13 @@ func (c *ChatGPT) ListAllModelsCore() ([]ChatGPTModel, error) {
 	return response.Data, nil
 }
 
-func (c *ChatGPT) ListAllModels() ([]string, error) {
+func (c *ChatGPT) ListAllModels(options ...CallOption) ([]string, error) {
 	if len(c.models) > 0 {
 		return c.models, nil
 	}
 
 	// Fetch all models
-	models, err := c.ListAllModelsCore()
+	models, err := c.ListAllModelsCore(NewCallOptions(options...).ctx())
 	if err != nil {
 		return nil, err
 	}
@@ -3,36 +3,47 @@ package llm
 import (
 	"context"
 	"fmt"
+	"time"
 	// "strings"
 
 	// "github.com/PuerkitoBio/goquery"
 	"github.com/robinmin/askllm/internal/config"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/anthropic"
-	// "github.com/robinmin/askllm/pkg/utils"
+
+	"github.com/robinmin/askllm/pkg/utils"
 )
 
 type Claude struct {
 	model   string
 	llm     llms.Model
-	context context.Context
+	timeout time.Duration // Time limit of a query, none if zero
 	chatURL string
 	// modelURL string
-	models []string // List of all available models
+	models []string                // List of all available models
+	params config.GenerationParams // Generation parameters of the engine config
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "claude",
+		DefaultModel: "claude-3-sonnet-20240229",
+		Capabilities: Capabilities{Tools: true},
+		New:          constructor(NewClaude),
+	})
 }
 
 func NewClaude(model string, cfg config.LLMEngineConfig) (*Claude, error) {
 	var llm llms.Model
 	var err error
 
-	ctx := context.Background()
 	if model == "" {
 		model = cfg.Model
 	}
 	if cfg.BaseURL != "" {
-		llm, err = anthropic.New(anthropic.WithToken(cfg.APIKey), anthropic.WithModel(model), anthropic.WithBaseURL(cfg.BaseURL))
+		llm, err = anthropic.New(anthropic.WithToken(cfg.APIKey), anthropic.WithModel(model), anthropic.WithBaseURL(cfg.BaseURL), anthropic.WithHTTPClient(utils.HTTPClient()))
 	} else {
-		llm, err = anthropic.New(anthropic.WithToken(cfg.APIKey), anthropic.WithModel(model))
+		llm, err = anthropic.New(anthropic.WithToken(cfg.APIKey), anthropic.WithModel(model), anthropic.WithHTTPClient(utils.HTTPClient()))
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Claude: %v", err)
@@ -41,24 +52,60 @@ func NewClaude(model string, cfg config.LLMEngineConfig) (*Claude, error) {
 	return &Claude{
 		model:   model,
 		llm:     llm,
-		context: ctx,
+		timeout: cfg.Timeout,
 		chatURL: cfg.BaseURL + "/chat/completions",
 		// modelURL: "https://docs.anthropic.com/en/docs/about-claude/models#model-names",
+		params: cfg.GenerationParams,
 	}, nil
 }
 
-func (c *Claude) Query(prompt string) (string, error) {
-	result, err := llms.GenerateFromSinglePrompt(
-		c.context, c.llm, prompt,
-		llms.WithTemperature(0.2),
+func (c *Claude) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	ctx, cancel := callOpts.contextWithin(c.timeout)
+	defer cancel()
+	result, err := generateContent(
+		ctx, c.llm, interleaveToolCalls(messages), onChunk, callOpts, c.params,
 		llms.WithModel(c.model),
 	)
 	if err != nil {
-		return "", fmt.Errorf("Claude query failed: %v", err)
+		return nil, fmt.Errorf("Claude query failed: %w", err)
 	}
 	return result, nil
 }
 
+// interleaveToolCalls splits the assistant messages calling several tools into one message per tool call, each one
+// followed by its result, as langchaingo only sends the first part of an assistant message to anthropic
+func interleaveToolCalls(messages []Message) []Message {
+	results := map[string]Message{}
+	for _, msg := range messages {
+		if msg.Role == RoleTool {
+			results[msg.ToolCallID] = msg
+		}
+	}
+
+	interleaved := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg.Role == RoleTool:
+			if _, ok := results[msg.ToolCallID]; ok {
+				// not moved next to its tool call
+				interleaved = append(interleaved, msg)
+			}
+		case len(msg.ToolCalls) > 1:
+			for _, call := range msg.ToolCalls {
+				interleaved = append(interleaved, Message{Role: msg.Role, ToolCalls: []ToolCall{call}})
+				if result, ok := results[call.ID]; ok {
+					interleaved = append(interleaved, result)
+					delete(results, call.ID)
+				}
+			}
+		default:
+			interleaved = append(interleaved, msg)
+		}
+	}
+	return interleaved
+}
+
 type ClaudeModel struct {
 	ID              string
 	Description     string
@@ -66,12 +113,12 @@ type ClaudeModel struct {
 	MaxOutputTokens int
 }
 
-func (c *Claude) ListAllModelsCore() ([]ClaudeModel, error) {
+func (c *Claude) ListAllModelsCore(ctx context.Context) ([]ClaudeModel, error) {
 	// headers := map[string]string{
 	// 	"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
 	// }
 
-	// responseBody, err := utils.APIRequestCore("GET", c.modelURL, nil, headers)
+	// responseBody, err := utils.APIRequestCore(ctx, "GET", c.modelURL, nil, headers)
 	// if err != nil {
 	// 	return nil, fmt.Errorf("error fetching Anthropic documentation page: %v", err)
 	// }
@@ -143,12 +190,12 @@ func (c *Claude) ListAllModelsCore() ([]ClaudeModel, error) {
 	return models, nil
 }
 
-func (c *Claude) ListAllModels() ([]string, error) {
+func (c *Claude) ListAllModels(options ...CallOption) ([]string, error) {
 	if len(c.models) > 0 {
 		return c.models, nil
 	}
 
-	models, err := c.ListAllModelsCore()
+	models, err := c.ListAllModelsCore(NewCallOptions(options...).ctx())
 	if err != nil {
 		return nil, err
 	}
@@ -158,3 +205,21 @@ func (c *Claude) ListAllModels() ([]string, error) {
 	}
 	return c.models, nil
 }
+
+func (c *Claude) DescribeModels(options ...CallOption) ([]ModelInfo, error) {
+	models, err := c.ListAllModelsCore(NewCallOptions(options...).ctx())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ModelInfo, 0, len(models))
+	for _, model := range models {
+		result = append(result, ModelInfo{
+			ID:              model.ID,
+			Description:     model.Description,
+			ContextWindow:   model.ContentWindow,
+			MaxOutputTokens: model.MaxOutputTokens,
+			Listed:          true,
+		})
+	}
+	return result, nil
+}
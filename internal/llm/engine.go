@@ -1,19 +1,230 @@
 package llm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
 
 	"github.com/robinmin/askllm/internal/config"
 	"github.com/robinmin/askllm/pkg/utils/log"
 )
 
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message represents a single turn of a conversation
+type Message struct {
+	Role       string     `json:"role"`                   // One of system, user, assistant or tool
+	Content    string     `json:"content"`                // Text content of the message
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Tools called by the assistant
+	ToolCallID string     `json:"tool_call_id,omitempty"` // Tool call answered by a tool message
+}
+
+// Tool is a function the model can call, described by the JSON schema of its arguments
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON schema of the arguments object
+}
+
+// ToolCall is a call of a tool requested by the model, in the format of the OpenAI chat completions API
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"` // Always function
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall is the function and arguments of a tool call
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON object of the arguments
+}
+
+// NewToolCall creates the call of the named tool with its JSON arguments
+func NewToolCall(id, name, arguments string) ToolCall {
+	return ToolCall{ID: id, Type: "function", Function: FunctionCall{Name: name, Arguments: arguments}}
+}
+
+// Usage is the token usage of a query
+type Usage struct {
+	PromptTokens     int  `yaml:"prompt_tokens" json:"prompt_tokens"`             // Number of tokens of the prompt
+	CompletionTokens int  `yaml:"completion_tokens" json:"completion_tokens"`     // Number of tokens generated
+	Estimated        bool `yaml:"estimated,omitempty" json:"estimated,omitempty"` // Not reported by the provider but estimated
+}
+
+// TotalTokens returns the number of prompt and completion tokens
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Add sums up the usage of two queries
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Estimated:        u.Estimated || other.Estimated,
+	}
+}
+
+// Response is the result of a query
+type Response struct {
+	Content   string     // Text content of the response
+	ToolCalls []ToolCall // Tools to call before the model can answer, only if tools are given
+	Usage     Usage      // Token usage reported by the provider, or estimated
+	Engine    string     // LLM engine answered, set by a fallback chain only
+	Model     string     // LLM model answered, set by a fallback chain only
+	Cached    bool       // Served from the response cache
+}
+
+// EstimateTokens roughly estimates the number of tokens of the text, about four characters per token
+func EstimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+// EstimateUsage estimates the usage of a query for the providers not reporting it
+func EstimateUsage(messages []Message, content string) Usage {
+	usage := Usage{CompletionTokens: EstimateTokens(content), Estimated: true}
+	for _, msg := range messages {
+		usage.PromptTokens += EstimateTokens(msg.Content)
+	}
+	return usage
+}
+
+// StreamFunc is called for every chunk of a streaming response. Return an error to stop streaming early.
+type StreamFunc func(chunk string) error
+
+// DefaultTemperature is the built-in sampling temperature if none is configured
+const DefaultTemperature = 0.2
+
+// CallOptions are the options of a single query. Engines ignore the options they do not support.
+type CallOptions struct {
+	Context        context.Context         // Context of the query, aborting the query once done; none by default
+	JSONMode       bool                    // Ask the model to reply with a valid JSON document
+	Tools          []Tool                  // Tools the model can call instead of answering
+	CLIParams      config.GenerationParams // Generation parameters from the command line
+	TemplateParams config.GenerationParams // Generation parameters from the prompt template
+}
+
+type CallOption func(*CallOptions)
+
+// WithContext sets the context of the query, so that the query is aborted once the context is done
+func WithContext(ctx context.Context) CallOption {
+	return func(o *CallOptions) {
+		o.Context = ctx
+	}
+}
+
+// WithJSONMode asks the model to reply with a valid JSON document
+func WithJSONMode() CallOption {
+	return func(o *CallOptions) {
+		o.JSONMode = true
+	}
+}
+
+// WithTools lets the model call the tools. The engines supporting them return the calls in the response rather than
+// streaming it.
+func WithTools(tools ...Tool) CallOption {
+	return func(o *CallOptions) {
+		o.Tools = tools
+	}
+}
+
+// WithParams sets the generation parameters from the command line and the prompt template
+func WithParams(cli, template config.GenerationParams) CallOption {
+	return func(o *CallOptions) {
+		o.CLIParams = cli
+		o.TemplateParams = template
+	}
+}
+
+// Params resolves the generation parameters of the query in the order of command line, prompt template,
+// engine config and built-in default
+func (o CallOptions) Params(engine config.GenerationParams) config.GenerationParams {
+	temperature := DefaultTemperature
+	defaults := config.GenerationParams{Temperature: &temperature}
+	return defaults.Merge(engine).Merge(o.TemplateParams).Merge(o.CLIParams)
+}
+
+// contextWithin returns the context of the query bounded by the timeout of the engine if positive, along with
+// the function to release it
+func (o CallOptions) contextWithin(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := o.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// ctx returns the context of the call, or the background one if none is given
+func (o CallOptions) ctx() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// NewCallOptions applies all options on the default call options
+func NewCallOptions(options ...CallOption) CallOptions {
+	opts := CallOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// PrependSystem puts the system prompt in front of the conversation, unless it is empty
+func PrependSystem(system string, messages []Message) []Message {
+	if strings.TrimSpace(system) == "" {
+		return messages
+	}
+	return append([]Message{{Role: RoleSystem, Content: system}}, messages...)
+}
+
+// Engine is an LLM provider. Query and Chat get its responses without streaming.
 type Engine interface {
-	Query(prompt string) (string, error)
-	ListAllModels() ([]string, error)
+	ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error)
+	ListAllModels(options ...CallOption) ([]string, error) // Only the context of the options applies
 }
 
-func NewEngine(engineType, model string, cfg *config.Config) (Engine, error) {
+// Query sends the prompt as a single user message to the engine
+func Query(engine Engine, prompt string, options ...CallOption) (*Response, error) {
+	return Chat(engine, []Message{{Role: RoleUser, Content: prompt}}, options...)
+}
+
+// Chat sends the conversation to the engine without streaming the response
+func Chat(engine Engine, messages []Message, options ...CallOption) (*Response, error) {
+	return engine.ChatStream(messages, nil, options...)
+}
+
+// CloseEngine releases the resources held by the engine if any, e.g. the process of a plugin. The engines created by
+// NewEngine are closed once done with.
+func CloseEngine(engine Engine) {
+	closer, ok := engine.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		log.Warnf("Failed to close the LLM engine: %v", err)
+	}
+}
+
+// ResolveEngine returns the names of the engine and model created by NewEngine for the given ones
+func ResolveEngine(engineType, model string, cfg *config.Config) (string, string) {
+	engineType, model = cfg.Aliases.Resolve(engineType, model)
+
 	// use provided engine type first
 	tmpEngine := strings.TrimSpace(strings.ToLower(engineType))
 	tmpModel := strings.TrimSpace(strings.ToLower(model))
@@ -34,68 +245,346 @@ func NewEngine(engineType, model string, cfg *config.Config) (Engine, error) {
 		tmpEngine = "ollama" // if still no engine type is provided, use ollama
 		engineCfg = cfg.LLMEngines[tmpEngine]
 	}
+	if tmpModel == "" {
+		tmpModel = configuredModel(tmpEngine, engineCfg)
+	}
+	return tmpEngine, tmpModel
+}
+
+// NewEngine creates the engine of the model, resolving the model alias of the config if any. The default engine of
+// the config (or ollama) is used if none is given, and the default model of the engine if none is given either.
+func NewEngine(engineType, model string, cfg *config.Config) (Engine, error) {
+	tmpEngine, tmpModel := ResolveEngine(engineType, model, cfg)
+	engineCfg := cfg.LLMEngines[tmpEngine]
 
 	log.Infof("Using LLM engine: %s, model: %s", tmpEngine, tmpModel)
 
-	switch tmpEngine {
-	case "chatgpt":
-		return NewChatGPT(tmpModel, engineCfg)
-	case "gemini":
-		return NewGemini(tmpModel, engineCfg)
-	case "ollama":
-		return NewOllama(tmpModel, engineCfg)
-	case "claude":
-		return NewClaude(tmpModel, engineCfg)
-	case "groq":
-		return NewGroq(tmpModel, engineCfg)
-	default:
-		return nil, fmt.Errorf("unsupported LLM engine: %s", tmpEngine)
+	primary, err := newSingleEngine(tmpEngine, tmpModel, engineCfg)
+	if err != nil || len(engineCfg.Fallback) == 0 {
+		return primary, err
+	}
+	return newFallbackChain(FallbackMember{Engine: tmpEngine, Model: tmpModel, LLM: primary}, engineCfg.Fallback, cfg)
+}
+
+// newFallbackChain appends the engines of the fallback list to the primary engine. Unknown engines, or
+// the ones failed to initialize, are skipped with a warning.
+func newFallbackChain(primary FallbackMember, fallback []string, cfg *config.Config) (Engine, error) {
+	members := []FallbackMember{primary}
+	for _, item := range fallback {
+		engineName, modelName, _ := strings.Cut(item, ":")
+		engineName = strings.TrimSpace(strings.ToLower(engineName))
+		modelName = strings.TrimSpace(modelName)
+
+		engineCfg, ok := cfg.LLMEngines[engineName]
+		if !ok {
+			log.Warnf("Skipped unknown fallback engine: %s", engineName)
+			continue
+		}
+		if modelName == "" {
+			modelName = GetDefaultModel(engineName)
+		}
+		if modelName == "" {
+			modelName = configuredModel(engineName, engineCfg)
+		}
+		member, err := newSingleEngine(engineName, modelName, engineCfg)
+		if err != nil {
+			log.Warnf("Skipped fallback engine %s: %v", engineName, err)
+			continue
+		}
+		members = append(members, FallbackMember{Engine: engineName, Model: modelName, LLM: member})
+	}
+	return NewFallback(members...)
+}
+
+// newSingleEngine creates the engine of its kind without its fallback chain
+func newSingleEngine(engineName, model string, engineCfg config.LLMEngineConfig) (Engine, error) {
+	kind, err := kindOf(engineName, engineCfg)
+	if err != nil {
+		return nil, err
+	}
+	return kind.New(model, engineCfg)
+}
+
+// configuredModel returns the model of the engine config, or the default model of its kind
+func configuredModel(engineName string, engineCfg config.LLMEngineConfig) string {
+	if engineCfg.Model != "" {
+		return engineCfg.Model
 	}
+	if kind, err := kindOf(engineName, engineCfg); err == nil {
+		return kind.DefaultModel
+	}
+	return ""
 }
 
+// GetDefaultModel returns the default model of the built-in engine, or an empty string for an engine
+// declared by the config only
 func GetDefaultModel(engine string) string {
-	switch strings.TrimSpace(strings.ToLower(engine)) {
-	case "chatgpt":
-		return "gpt-4o-mini"
-	case "gemini":
-		return "gemini-1.5-pro"
-	case "ollama":
-		return "gemma2"
-	case "claude":
-		return "claude-3-sonnet-20240229"
-	case "groq":
-		return "gemma2-9b-it"
-	default:
-		return ""
+	if kind, ok := LookupKind(engine); ok {
+		return kind.DefaultModel
 	}
+	return ""
 }
 
-func GetAllModels(engineType string, cfg *config.Config) (map[string][]string, error) {
-	result := map[string][]string{}
-	tmpEngine := strings.TrimSpace(strings.ToLower(engineType))
+// EngineResult is the result of a function run on a named engine
+type EngineResult[T any] struct {
+	Engine string
+	Value  T
+	Err    error
+}
+
+// ForEachEngine runs fn on the named engines concurrently, giving up on an engine after timeout if positive, or once
+// ctx is done. The results are returned in the order of the names, and passed to onResult (if not nil) in the same
+// order, each one as soon as it and the preceding ones are available. The context given to fn is done once its engine
+// is given up on, and its result is discarded.
+func ForEachEngine[T any](ctx context.Context, engineNames []string, timeout time.Duration, fn func(ctx context.Context, engineName string) (T, error),
+	onResult func(EngineResult[T])) []EngineResult[T] {
+	type indexed struct {
+		index  int
+		result EngineResult[T]
+	}
+
+	resultCh := make(chan indexed, len(engineNames))
+	for i, engineName := range engineNames {
+		go func() {
+			resultCh <- indexed{i, runWithin(ctx, engineName, timeout, fn)}
+		}()
+	}
 
-	if _, ok := cfg.LLMEngines[tmpEngine]; ok {
-		// if found, return models for specified engine
-		if llmObj, err := NewEngine(engineType, "", cfg); err == nil {
-			if tmp, err := llmObj.ListAllModels(); err == nil {
-				result[tmpEngine] = tmp
+	results := make([]EngineResult[T], len(engineNames))
+	done := make([]bool, len(engineNames))
+	next := 0
+	for range engineNames {
+		item := <-resultCh
+		results[item.index], done[item.index] = item.result, true
+		for ; next < len(engineNames) && done[next]; next++ {
+			if onResult != nil {
+				onResult(results[next])
 			}
-		} else {
+		}
+	}
+	return results
+}
+
+// runWithin runs fn on the engine with a context of parent bounded by timeout if positive, giving up once it is done
+func runWithin[T any](parent context.Context, engineName string, timeout time.Duration, fn func(ctx context.Context, engineName string) (T, error)) EngineResult[T] {
+	ctx, cancel := context.WithCancel(parent)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	}
+	defer cancel()
+
+	resultCh := make(chan EngineResult[T], 1)
+	go func() {
+		value, err := fn(ctx, engineName)
+		resultCh <- EngineResult[T]{Engine: engineName, Value: value, Err: err}
+	}()
+
+	select {
+	case result := <-resultCh:
+		return result
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			return EngineResult[T]{Engine: engineName, Err: err}
+		}
+		return EngineResult[T]{Engine: engineName, Err: fmt.Errorf("timed out after %s: %w", timeout, context.DeadlineExceeded)}
+	}
+}
+
+// GetAllModels lists the model names of the engine, or of all engines if it is not configured, concurrently within
+// the list timeout of the config. The engines failing to list their models are left out, and their errors are joined
+// into the returned error along with the models of the other engines.
+func GetAllModels(engineType string, cfg *config.Config) (map[string][]string, error) {
+	engineNames := []string{strings.TrimSpace(strings.ToLower(engineType))}
+	if _, ok := cfg.LLMEngines[engineNames[0]]; !ok {
+		engineNames = engineNames[:0]
+		for engineName := range cfg.LLMEngines {
+			engineNames = append(engineNames, engineName)
+		}
+		sort.Strings(engineNames)
+	}
+
+	listTimeout := cfg.Sys.ListTimeout
+	if listTimeout <= 0 {
+		listTimeout = config.DefaultListTimeout
+	}
+	result := map[string][]string{}
+	var errs []error
+	ForEachEngine(context.Background(), engineNames, listTimeout, func(ctx context.Context, engineName string) ([]string, error) {
+		llmObj, err := NewEngine(engineName, "", cfg)
+		if err != nil {
 			return nil, err
 		}
-	} else {
-		// if not found, return models for all engines
-		for tmpEngine := range cfg.LLMEngines {
-			if llmObj, err := NewEngine(tmpEngine, "", cfg); err == nil {
-				if tmp, err := llmObj.ListAllModels(); err == nil {
-					result[tmpEngine] = tmp
-				} else {
-					return nil, err
-				}
-			} else {
-				return nil, err
+		defer CloseEngine(llmObj)
+		return llmObj.ListAllModels(WithContext(ctx))
+	}, func(listed EngineResult[[]string]) {
+		if listed.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", listed.Engine, listed.Err))
+			return
+		}
+		result[listed.Engine] = listed.Value
+	})
+	return result, errors.Join(errs...)
+}
+
+// toMessageContents converts the conversation into the langchaingo message format. The tool calls of an assistant
+// message come ahead of its text, and a tool message carries the name of the tool it answers.
+func toMessageContents(messages []Message) []llms.MessageContent {
+	contents := make([]llms.MessageContent, 0, len(messages))
+	toolNames := map[string]string{}
+	for _, msg := range messages {
+		var role llms.ChatMessageType
+		switch strings.ToLower(msg.Role) {
+		case RoleSystem:
+			role = llms.ChatMessageTypeSystem
+		case RoleAssistant:
+			role = llms.ChatMessageTypeAI
+		case RoleTool:
+			contents = append(contents, llms.MessageContent{
+				Role:  llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{ToolCallID: msg.ToolCallID, Name: toolNames[msg.ToolCallID], Content: msg.Content}},
+			})
+			continue
+		default:
+			role = llms.ChatMessageTypeHuman
+		}
+		if len(msg.ToolCalls) == 0 {
+			contents = append(contents, llms.TextParts(role, msg.Content))
+			continue
+		}
+
+		content := llms.MessageContent{Role: role}
+		for _, call := range msg.ToolCalls {
+			toolNames[call.ID] = call.Function.Name
+			content.Parts = append(content.Parts, llms.ToolCall{
+				ID:           call.ID,
+				Type:         "function",
+				FunctionCall: &llms.FunctionCall{Name: call.Function.Name, Arguments: call.Function.Arguments},
+			})
+		}
+		if msg.Content != "" {
+			content.Parts = append(content.Parts, llms.TextContent{Text: msg.Content})
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+// toolOptions converts the tools into langchaingo call options
+func toolOptions(tools []Tool) []llms.CallOption {
+	if len(tools) == 0 {
+		return nil
+	}
+	defs := make([]llms.Tool, 0, len(tools))
+	for _, tool := range tools {
+		defs = append(defs, llms.Tool{
+			Type:     "function",
+			Function: &llms.FunctionDefinition{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	return []llms.CallOption{llms.WithTools(defs)}
+}
+
+// paramOptions converts the generation parameters into langchaingo call options
+func paramOptions(params config.GenerationParams) []llms.CallOption {
+	var options []llms.CallOption
+	if params.Temperature != nil {
+		options = append(options, llms.WithTemperature(*params.Temperature))
+	}
+	if params.TopP != nil {
+		options = append(options, llms.WithTopP(*params.TopP))
+	}
+	if params.MaxTokens != nil {
+		options = append(options, llms.WithMaxTokens(*params.MaxTokens))
+	}
+	if len(params.Stop) > 0 {
+		options = append(options, llms.WithStopWords(params.Stop))
+	}
+	if params.Seed != nil {
+		options = append(options, llms.WithSeed(*params.Seed))
+	}
+	return options
+}
+
+// usageKeys are the generation info keys of prompt and completion tokens used by the langchaingo providers
+var usageKeys = [][2]string{
+	{"PromptTokens", "CompletionTokens"}, // openai, ollama
+	{"InputTokens", "OutputTokens"},      // anthropic
+	{"input_tokens", "output_tokens"},    // googleai
+}
+
+// usageFromInfo extracts the usage from the generation info, or estimates it if absent
+func usageFromInfo(info map[string]any, messages []Message, content string) Usage {
+	for _, keys := range usageKeys {
+		promptTokens, ok1 := toInt(info[keys[0]])
+		completionTokens, ok2 := toInt(info[keys[1]])
+		if (ok1 || ok2) && promptTokens+completionTokens > 0 {
+			return Usage{PromptTokens: promptTokens, CompletionTokens: completionTokens}
+		}
+	}
+	return EstimateUsage(messages, content)
+}
+
+func toInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
+
+// generateContent sends the whole conversation to a langchaingo model and returns the first choice.
+// The response is streamed into onChunk if it is not nil. With tools, the text and tool calls of all choices are
+// returned instead, as some providers return them as separate choices, and the response is passed to onChunk at once.
+func generateContent(ctx context.Context, model llms.Model, messages []Message, onChunk StreamFunc, callOpts CallOptions, engineParams config.GenerationParams, options ...llms.CallOption) (*Response, error) {
+	options = append(options, paramOptions(callOpts.Params(engineParams))...)
+	options = append(options, toolOptions(callOpts.Tools)...)
+	if callOpts.JSONMode {
+		options = append(options, llms.WithJSONMode())
+	}
+	if onChunk != nil && len(callOpts.Tools) == 0 {
+		options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			return onChunk(string(chunk))
+		}))
+	}
+	resp, err := model.GenerateContent(ctx, toMessageContents(messages), options...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in response")
+	}
+	choice := resp.Choices[0]
+	if len(callOpts.Tools) == 0 {
+		return &Response{Content: choice.Content, Usage: usageFromInfo(choice.GenerationInfo, messages, choice.Content)}, nil
+	}
+
+	response := &Response{}
+	for _, choice := range resp.Choices {
+		response.Content += choice.Content
+		for _, call := range choice.ToolCalls {
+			if call.FunctionCall == nil {
+				continue
+			}
+			id := call.ID
+			if id == "" {
+				// gemini does not identify its function calls
+				id = fmt.Sprintf("call_%d", len(response.ToolCalls)+1)
 			}
+			response.ToolCalls = append(response.ToolCalls, NewToolCall(id, call.FunctionCall.Name, call.FunctionCall.Arguments))
+		}
+	}
+	response.Usage = usageFromInfo(choice.GenerationInfo, messages, response.Content)
+	if onChunk != nil && response.Content != "" {
+		if err := onChunk(response.Content); err != nil {
+			return nil, err
 		}
 	}
-	return result, nil
+	return response, nil
 }
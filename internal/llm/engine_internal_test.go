@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestUsageFromInfo(t *testing.T) {
+	messages := []Message{{Role: RoleUser, Content: "hello world"}}
+
+	tests := []struct {
+		name     string
+		info     map[string]any
+		expected Usage
+	}{
+		{"OpenAI", map[string]any{"PromptTokens": 12, "CompletionTokens": 34, "TotalTokens": 46}, Usage{PromptTokens: 12, CompletionTokens: 34}},
+		{"Anthropic", map[string]any{"InputTokens": 5, "OutputTokens": 6}, Usage{PromptTokens: 5, CompletionTokens: 6}},
+		{"GoogleAI", map[string]any{"input_tokens": int32(7), "output_tokens": int32(8)}, Usage{PromptTokens: 7, CompletionTokens: 8}},
+		{"Missing", nil, Usage{PromptTokens: 3, CompletionTokens: 2, Estimated: true}},
+		{"Zero", map[string]any{"PromptTokens": 0, "CompletionTokens": 0}, Usage{PromptTokens: 3, CompletionTokens: 2, Estimated: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, usageFromInfo(tt.info, messages, "hi there"))
+		})
+	}
+}
+
+func TestToMessageContents_Tools(t *testing.T) {
+	contents := toMessageContents([]Message{
+		{Role: RoleUser, Content: "what is in go.mod?"},
+		{Role: RoleAssistant, Content: "Let me check", ToolCalls: []ToolCall{NewToolCall("call_1", "read_file", `{"path":"go.mod"}`)}},
+		{Role: RoleTool, Content: "module x", ToolCallID: "call_1"},
+	})
+
+	assert.Len(t, contents, 3)
+	assert.Equal(t, llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: []llms.ContentPart{
+		llms.ToolCall{ID: "call_1", Type: "function", FunctionCall: &llms.FunctionCall{Name: "read_file", Arguments: `{"path":"go.mod"}`}},
+		llms.TextContent{Text: "Let me check"},
+	}}, contents[1])
+	assert.Equal(t, llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{
+		llms.ToolCallResponse{ToolCallID: "call_1", Name: "read_file", Content: "module x"},
+	}}, contents[2])
+}
+
+func TestInterleaveToolCalls(t *testing.T) {
+	first, second := NewToolCall("call_1", "read_file", "{}"), NewToolCall("call_2", "grep", "{}")
+	interleaved := interleaveToolCalls([]Message{
+		{Role: RoleUser, Content: "hi"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{first, second}},
+		{Role: RoleTool, Content: "one", ToolCallID: "call_1"},
+		{Role: RoleTool, Content: "two", ToolCallID: "call_2"},
+	})
+
+	assert.Equal(t, []Message{
+		{Role: RoleUser, Content: "hi"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{first}},
+		{Role: RoleTool, Content: "one", ToolCallID: "call_1"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{second}},
+		{Role: RoleTool, Content: "two", ToolCallID: "call_2"},
+	}, interleaved)
+}
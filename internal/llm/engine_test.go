@@ -0,0 +1,141 @@
+package llm_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+)
+
+func float64Ptr(v float64) *float64 { return &v }
+func intPtr(v int) *int             { return &v }
+
+func TestCallOptions_Params(t *testing.T) {
+	t.Run("BuiltInDefault", func(t *testing.T) {
+		params := testee.NewCallOptions().Params(config.GenerationParams{})
+		assert.Equal(t, testee.DefaultTemperature, *params.Temperature)
+		assert.Nil(t, params.TopP)
+		assert.Nil(t, params.MaxTokens)
+	})
+
+	t.Run("ResolutionOrder", func(t *testing.T) {
+		var engineCfg config.LLMEngineConfig
+		err := yaml.Unmarshal([]byte("model: gemma2\ntemperature: 0.7\ntop_p: 0.9\nmax_tokens: 512\nseed: 42\n"), &engineCfg)
+		assert.NoError(t, err)
+
+		template := config.GenerationParams{TopP: float64Ptr(0.5), Stop: []string{"END"}}
+		cli := config.GenerationParams{Temperature: float64Ptr(0), MaxTokens: intPtr(100)}
+
+		params := testee.NewCallOptions(testee.WithParams(cli, template)).Params(engineCfg.GenerationParams)
+		assert.Equal(t, 0.0, *params.Temperature) // command line, even if zero
+		assert.Equal(t, 0.5, *params.TopP)        // prompt template
+		assert.Equal(t, 100, *params.MaxTokens)   // command line
+		assert.Equal(t, []string{"END"}, params.Stop)
+		assert.Equal(t, 42, *params.Seed) // engine config
+	})
+}
+
+func TestPrependSystem(t *testing.T) {
+	messages := []testee.Message{{Role: testee.RoleUser, Content: "hello"}}
+
+	assert.Equal(t, messages, testee.PrependSystem("", messages))
+	assert.Equal(t, messages, testee.PrependSystem("  \n", messages))
+
+	result := testee.PrependSystem("be brief", messages)
+	assert.Len(t, result, 2)
+	assert.Equal(t, testee.Message{Role: testee.RoleSystem, Content: "be brief"}, result[0])
+	assert.Equal(t, messages[0], result[1])
+}
+
+func TestResolveEngine(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"mock":   {},
+			"local":  {Kind: "mock", Model: "mock-large"},
+			"ollama": {},
+		},
+		Aliases: config.Aliases{"big": {Engine: "local"}},
+	}
+	cfg.Sys.DefaultEngine = "local"
+
+	tests := []struct {
+		engine, model  string
+		expectedEngine string
+		expectedModel  string
+	}{
+		{"mock", "mock-small", "mock", "mock-small"},
+		{"MOCK", "", "mock", testee.MockModels[0]},
+		{"", "", "local", "mock-large"},
+		{"", "big", "local", "mock-large"},
+		{"unknown", "", "ollama", "gemma2"},
+	}
+	for _, tt := range tests {
+		engine, model := testee.ResolveEngine(tt.engine, tt.model, cfg)
+		assert.Equal(t, tt.expectedEngine, engine, tt.engine+"/"+tt.model)
+		assert.Equal(t, tt.expectedModel, model, tt.engine+"/"+tt.model)
+	}
+}
+
+func TestGetAllModels(t *testing.T) {
+	cfg := &config.Config{LLMEngines: map[string]config.LLMEngineConfig{
+		"mock":   {},
+		"broken": {Kind: "plugin", Command: []string{filepath.Join(t.TempDir(), "missing-plugin")}},
+	}}
+
+	// the failure of an engine does not hide the models of the others
+	models, err := testee.GetAllModels("", cfg)
+	assert.ErrorContains(t, err, "broken: ")
+	assert.Equal(t, map[string][]string{"mock": testee.MockModels}, models)
+
+	models, err = testee.GetAllModels("mock", cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"mock": testee.MockModels}, models)
+}
+
+func TestForEachEngine(t *testing.T) {
+	// the later engines answer first, the slow one too late
+	latencies := map[string]time.Duration{"a": 60 * time.Millisecond, "b": 30 * time.Millisecond, "c": 0, "slow": time.Second}
+	var order []string
+	cancelled := make(chan error, 1)
+	results := testee.ForEachEngine(context.Background(), []string{"a", "b", "c", "slow"}, 200*time.Millisecond, func(ctx context.Context, engineName string) (string, error) {
+		select {
+		case <-time.After(latencies[engineName]):
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+			return "", ctx.Err()
+		}
+		if engineName == "b" {
+			return "", fmt.Errorf("provider is down")
+		}
+		return engineName + "!", nil
+	}, func(result testee.EngineResult[string]) {
+		order = append(order, result.Engine)
+	})
+
+	assert.Equal(t, []string{"a", "b", "c", "slow"}, order)
+	assert.Len(t, results, 4)
+	assert.Equal(t, testee.EngineResult[string]{Engine: "a", Value: "a!"}, results[0])
+	assert.EqualError(t, results[1].Err, "provider is down")
+	assert.Equal(t, "c!", results[2].Value)
+	assert.ErrorIs(t, results[3].Err, context.DeadlineExceeded)
+	assert.Empty(t, results[3].Value)
+
+	// the slow engine is told to give up
+	assert.ErrorIs(t, <-cancelled, context.DeadlineExceeded)
+
+	// all engines are given up on once the run is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = testee.ForEachEngine(ctx, []string{"a", "slow"}, time.Minute, func(ctx context.Context, engineName string) (string, error) {
+		time.Sleep(latencies[engineName])
+		return engineName + "!", nil
+	}, nil)
+	assert.ErrorIs(t, results[1].Err, context.Canceled)
+}
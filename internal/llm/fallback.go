@@ -0,0 +1,142 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/robinmin/askllm/pkg/utils"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// statusPattern extracts the HTTP status code from the error messages of the providers
+var statusPattern = regexp.MustCompile(`(?i)(?:status code|status|error)[: ]+(\d{3})\b`)
+
+// transportErrors are the error messages of failed connections
+var transportErrors = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"i/o timeout",
+	"deadline exceeded",
+	"server misbehaving",
+	"giving up after", // retries exhausted by the HTTP client
+	"unexpected eof",
+}
+
+// IsRetryable reports whether the error is a transport error, a timeout, a rate limit (429) or a server error
+// (5xx), so that it is worth trying the next engine of a fallback chain. A cancelled query is not.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *utils.StatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// most providers only report the status in the error message
+	message := strings.ToLower(err.Error())
+	if matches := statusPattern.FindStringSubmatch(message); matches != nil {
+		code, _ := strconv.Atoi(matches[1])
+		return isRetryableStatus(code)
+	}
+	for _, text := range transportErrors {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// FallbackMember is an engine of a fallback chain
+type FallbackMember struct {
+	Engine string // Name of the LLM engine
+	Model  string // Name of the model
+	LLM    Engine
+}
+
+func (m FallbackMember) String() string {
+	return m.Engine + "/" + m.Model
+}
+
+// Fallback tries the engines in order, moving on to the next one on retryable errors only
+type Fallback struct {
+	members []FallbackMember
+}
+
+func NewFallback(members ...FallbackMember) (*Fallback, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("empty fallback chain")
+	}
+	return &Fallback{members: members}, nil
+}
+
+// ChatStream falls back to the next engine only if nothing has been streamed yet, so that the
+// output is never mixed from several engines. It gives up once the context of the query is done, whereas
+// the timeout of an engine moves on to the next one.
+func (f *Fallback) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	ctx := NewCallOptions(options...).Context
+	var lastErr error
+	for i, member := range f.members {
+		streamed := false
+		var memberChunk StreamFunc
+		if onChunk != nil {
+			memberChunk = func(chunk string) error {
+				streamed = true
+				return onChunk(chunk)
+			}
+		}
+
+		response, err := member.LLM.ChatStream(messages, memberChunk, options...)
+		if err == nil {
+			log.Infof("Answered by %s", member)
+			response.Engine = member.Engine
+			response.Model = member.Model
+			return response, nil
+		}
+
+		lastErr = err
+		if streamed || !IsRetryable(err) || i == len(f.members)-1 || (ctx != nil && ctx.Err() != nil) {
+			break
+		}
+		log.Warnf("%s failed, falling back to %s: %v", member, f.members[i+1], err)
+	}
+	return nil, lastErr
+}
+
+// ListAllModels lists the models of the primary engine
+func (f *Fallback) ListAllModels(options ...CallOption) ([]string, error) {
+	return f.members[0].LLM.ListAllModels(options...)
+}
+
+// Close closes all engines of the chain
+func (f *Fallback) Close() error {
+	var errs []error
+	for _, member := range f.members {
+		if closer, ok := member.LLM.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DescribeModels describes the models of the primary engine
+func (f *Fallback) DescribeModels(options ...CallOption) ([]ModelInfo, error) {
+	return DescribeModels(f.members[0].LLM, options...)
+}
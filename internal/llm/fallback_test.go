@@ -0,0 +1,155 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+// scripted fails with the error if any after streaming the chunks, or answers with its name
+func scripted(name string, err error, chunks ...string) *llmtest.Engine {
+	engine := llmtest.New(func(messages []testee.Message, options testee.CallOptions) (*testee.Response, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &testee.Response{Content: "answer from " + name}, nil
+	})
+	engine.Name = name
+	engine.Chunks = chunks
+	engine.Models = []string{name + "-model"}
+	return engine
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"Nil", nil, false},
+		{"RateLimited", &utils.StatusError{StatusCode: 429}, true},
+		{"ServerError", fmt.Errorf("Groq query failed: %w", &utils.StatusError{StatusCode: 503}), true},
+		{"Unauthorized", &utils.StatusError{StatusCode: 401}, false},
+		{"NetError", fmt.Errorf("Ollama query failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"OpenAIMessage", errors.New("ChatGPT query failed: API returned unexpected status code: 429: rate limit"), true},
+		{"GoogleMessage", errors.New("googleapi: Error 500: internal error"), true},
+		{"BadRequestMessage", errors.New("API returned unexpected status code: 400"), false},
+		{"ConnectionRefused", errors.New(`Post "http://127.0.0.1:11434/api/chat": dial tcp 127.0.0.1:11434: connect: connection refused`), true},
+		{"InvalidPrompt", errors.New("no choices in response"), false},
+		{"Cancelled", fmt.Errorf("Mock query failed: %w", context.Canceled), false},
+		{"TimedOut", fmt.Errorf("Mock query failed: %w", context.DeadlineExceeded), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, testee.IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestFallback_ChatStream(t *testing.T) {
+	newChain := func(engines ...*llmtest.Engine) *testee.Fallback {
+		var members []testee.FallbackMember
+		for _, engine := range engines {
+			members = append(members, testee.FallbackMember{Engine: engine.Name, Model: "m", LLM: engine})
+		}
+		chain, err := testee.NewFallback(members...)
+		assert.NoError(t, err)
+		return chain
+	}
+
+	t.Run("FallsBackOnRetryableError", func(t *testing.T) {
+		groq := scripted("groq", &utils.StatusError{StatusCode: 429})
+		chatgpt := scripted("chatgpt", errors.New("dial tcp: connection refused"))
+		ollama := scripted("ollama", nil)
+
+		response, err := testee.Chat(newChain(groq, chatgpt, ollama), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "answer from ollama", response.Content)
+		assert.Equal(t, "ollama", response.Engine)
+		assert.Equal(t, "m", response.Model)
+	})
+
+	t.Run("StopsOnOtherErrors", func(t *testing.T) {
+		groq := scripted("groq", &utils.StatusError{StatusCode: 401})
+		ollama := scripted("ollama", nil)
+
+		_, err := testee.Chat(newChain(groq, ollama), nil)
+		assert.Error(t, err)
+		assert.Equal(t, 0, len(ollama.Calls()))
+	})
+
+	t.Run("StopsOnceStreamed", func(t *testing.T) {
+		groq := scripted("groq", &utils.StatusError{StatusCode: 502}, "partial")
+		ollama := scripted("ollama", nil)
+
+		var chunks []string
+		_, err := newChain(groq, ollama).ChatStream(nil, func(chunk string) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		assert.Error(t, err)
+		assert.Equal(t, []string{"partial"}, chunks)
+		assert.Equal(t, 0, len(ollama.Calls()))
+	})
+
+	t.Run("ReturnsLastError", func(t *testing.T) {
+		groq := scripted("groq", &utils.StatusError{StatusCode: 429})
+		ollama := scripted("ollama", &utils.StatusError{StatusCode: 500})
+
+		_, err := testee.Chat(newChain(groq, ollama), nil)
+		assert.EqualError(t, err, "unexpected status code: 500")
+	})
+
+	t.Run("StopsOnceCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		groq := scripted("groq", fmt.Errorf("Groq query failed: %w", ctx.Err()))
+		ollama := scripted("ollama", nil)
+
+		_, err := testee.Chat(newChain(groq, ollama), nil, testee.WithContext(ctx))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 0, len(ollama.Calls()))
+	})
+
+	t.Run("FallsBackOnTimeout", func(t *testing.T) {
+		groq := scripted("groq", fmt.Errorf("Groq query failed: %w", context.DeadlineExceeded))
+		ollama := scripted("ollama", nil)
+
+		response, err := testee.Chat(newChain(groq, ollama), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "answer from ollama", response.Content)
+	})
+
+	t.Run("ListsModelsOfPrimary", func(t *testing.T) {
+		models, err := newChain(scripted("groq", nil), scripted("ollama", nil)).ListAllModels()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"groq-model"}, models)
+	})
+}
+
+func TestNewEngine_Fallback(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"groq":   {APIKey: "key", BaseURL: "http://127.0.0.1:1", Fallback: []string{"unknown", "ollama:llama3"}},
+			"ollama": {BaseURL: "http://127.0.0.1:1"},
+		},
+	}
+
+	engine, err := testee.NewEngine("groq", "gemma2-9b-it", cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &testee.Fallback{}, engine)
+
+	engine, err = testee.NewEngine("ollama", "", cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &testee.Ollama{}, engine)
+}
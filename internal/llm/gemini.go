@@ -3,7 +3,9 @@ package llm
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/googleai"
@@ -15,11 +17,21 @@ import (
 type Gemini struct {
 	model    string
 	llm      llms.Model
-	context  context.Context
+	timeout  time.Duration // Time limit of a query, none if zero
 	chatURL  string
 	modelURL string
-	models   []string // List of all available models
-	apiKey   string   // API token
+	models   []string                // List of all available models
+	apiKey   string                  // API token
+	params   config.GenerationParams // Generation parameters of the engine config
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "gemini",
+		DefaultModel: "gemini-1.5-pro",
+		Capabilities: Capabilities{ListModels: true, Tools: true},
+		New:          constructor(NewGemini),
+	})
 }
 
 func NewGemini(model string, cfg config.LLMEngineConfig) (*Gemini, error) {
@@ -27,7 +39,13 @@ func NewGemini(model string, cfg config.LLMEngineConfig) (*Gemini, error) {
 	if model == "" {
 		model = cfg.Model
 	}
-	llm, err := googleai.New(ctx, googleai.WithAPIKey(cfg.APIKey))
+	options := []googleai.Option{googleai.WithAPIKey(cfg.APIKey)}
+	if transport := utils.CustomTransport(); transport != nil {
+		// the Google client drops the API key along with a custom HTTP client, so that the key is sent in the header
+		httpClient := &http.Client{Transport: &apiKeyTransport{apiKey: cfg.APIKey, next: transport}}
+		options = append(options, googleai.WithRest(), googleai.WithHTTPClient(httpClient))
+	}
+	llm, err := googleai.New(ctx, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Gemini: %v", err)
 	}
@@ -35,22 +53,36 @@ func NewGemini(model string, cfg config.LLMEngineConfig) (*Gemini, error) {
 	return &Gemini{
 		model:    model,
 		llm:      llm,
-		context:  ctx,
+		timeout:  cfg.Timeout,
 		chatURL:  cfg.BaseURL + "/chat/completions",
 		modelURL: cfg.ExtraURL + "/models",
 		apiKey:   cfg.ExtraKey,
+		params:   cfg.GenerationParams,
 	}, nil
 }
 
-func (g *Gemini) Query(prompt string) (string, error) {
-	// result, err := g.llm.Call(g.context, prompt, llms.WithModel(g.model))
-	result, err := llms.GenerateFromSinglePrompt(
-		g.context, g.llm, prompt,
-		llms.WithTemperature(0.2),
+// apiKeyTransport sends the API key of Google AI in the request header
+type apiKeyTransport struct {
+	apiKey string
+	next   http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Goog-Api-Key", t.apiKey)
+	return t.next.RoundTrip(req)
+}
+
+func (g *Gemini) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	ctx, cancel := callOpts.contextWithin(g.timeout)
+	defer cancel()
+	result, err := generateContent(
+		ctx, g.llm, messages, onChunk, callOpts, g.params,
 		llms.WithModel(g.model),
 	)
 	if err != nil {
-		return "", fmt.Errorf("Gemini query failed: %v", err)
+		return nil, fmt.Errorf("Gemini query failed: %w", err)
 	}
 	return result, nil
 }
@@ -72,7 +104,7 @@ type GeminiModelListResponse struct {
 	Models []GeminiModel `json:"models"`
 }
 
-func (g *Gemini) ListAllModelsCore() ([]GeminiModel, error) {
+func (g *Gemini) ListAllModelsCore(ctx context.Context) ([]GeminiModel, error) {
 	headers := map[string]string{
 		"Content-Type": "application/json",
 	}
@@ -80,7 +112,7 @@ func (g *Gemini) ListAllModelsCore() ([]GeminiModel, error) {
 	// Add API key as a query parameter
 	url := fmt.Sprintf("%s?key=%s", g.modelURL, g.apiKey)
 
-	response, err := utils.APIGet[GeminiModelListResponse](url, headers)
+	response, err := utils.APIGet[GeminiModelListResponse](ctx, url, headers)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching Gemini models: %v", err)
 	}
@@ -96,12 +128,12 @@ func (g *Gemini) ListAllModelsCore() ([]GeminiModel, error) {
 	return response.Models, nil
 }
 
-func (g *Gemini) ListAllModels() ([]string, error) {
+func (g *Gemini) ListAllModels(options ...CallOption) ([]string, error) {
 	if len(g.models) > 0 {
 		return g.models, nil
 	}
 
-	models, err := g.ListAllModelsCore()
+	models, err := g.ListAllModelsCore(NewCallOptions(options...).ctx())
 	if err != nil {
 		return nil, err
 	}
@@ -117,3 +149,21 @@ func (g *Gemini) ListAllModels() ([]string, error) {
 	}
 	return g.models, nil
 }
+
+func (g *Gemini) DescribeModels(options ...CallOption) ([]ModelInfo, error) {
+	models, err := g.ListAllModelsCore(NewCallOptions(options...).ctx())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ModelInfo, 0, len(models))
+	for _, model := range models {
+		result = append(result, ModelInfo{
+			ID:              strings.TrimPrefix(model.Name, "models/"),
+			Description:     model.DisplayName,
+			ContextWindow:   model.InputTokenLimit,
+			MaxOutputTokens: model.OutputTokenLimit,
+			Listed:          true,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,96 @@
+// Package llmtest provides a fake LLM engine for the tests of the packages built on the engines
+package llmtest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/robinmin/askllm/internal/llm"
+)
+
+// AnswerFunc answers the conversation of a call to the fake engine
+type AnswerFunc func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error)
+
+// Call is a call received by the fake engine
+type Call struct {
+	Messages []llm.Message
+	Options  llm.CallOptions
+}
+
+// Engine is a fake engine answering by its function. It streams the chunks first if any, then the content of the
+// answer word by word, and records every call.
+type Engine struct {
+	Name   string // Tells the fake engines apart, e.g. in a fallback chain
+	Answer AnswerFunc
+	Chunks []string // Streamed before answering, e.g. a partial response before a failure
+	Models []string // Listed by ListAllModels
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// New creates a fake engine answering by the function
+func New(answer AnswerFunc) *Engine {
+	return &Engine{Answer: answer}
+}
+
+// Reply creates a fake engine answering every call with the content
+func Reply(content string) *Engine {
+	return New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+		return &llm.Response{Content: content, Usage: llm.EstimateUsage(messages, content)}, nil
+	})
+}
+
+func (e *Engine) ChatStream(messages []llm.Message, onChunk llm.StreamFunc, options ...llm.CallOption) (*llm.Response, error) {
+	callOpts := llm.NewCallOptions(options...)
+	e.mu.Lock()
+	e.calls = append(e.calls, Call{Messages: append([]llm.Message(nil), messages...), Options: callOpts})
+	e.mu.Unlock()
+
+	if err := stream(onChunk, e.Chunks); err != nil {
+		return nil, err
+	}
+	response, err := e.Answer(messages, callOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream(onChunk, strings.SplitAfter(response.Content, " ")); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (e *Engine) ListAllModels(options ...llm.CallOption) ([]string, error) {
+	return e.Models, nil
+}
+
+// Calls returns the calls received so far
+func (e *Engine) Calls() []Call {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Call(nil), e.calls...)
+}
+
+// LastCall returns the last call received, or the zero call if none
+func (e *Engine) LastCall() Call {
+	calls := e.Calls()
+	if len(calls) == 0 {
+		return Call{}
+	}
+	return calls[len(calls)-1]
+}
+
+func stream(onChunk llm.StreamFunc, chunks []string) error {
+	if onChunk == nil {
+		return nil
+	}
+	for _, chunk := range chunks {
+		if chunk == "" {
+			continue
+		}
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
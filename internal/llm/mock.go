@@ -0,0 +1,183 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+// MockModels are the fake models listed by the mock engine if the fixture lists none
+var MockModels = []string{"echo", "mock-small", "mock-large"}
+
+// MockFixture is the file of canned responses of the mock engine
+type MockFixture struct {
+	Models    []string          `yaml:"models,omitempty"`    // Fake models to list
+	Responses []MockResponseDef `yaml:"responses,omitempty"` // Canned responses, the first match wins
+}
+
+// MockResponseDef is a canned response, selected by matching the last user message against the pattern
+type MockResponseDef struct {
+	Match    string        `yaml:"match"`              // Regular expression to match the last user message
+	Response string        `yaml:"response,omitempty"` // Content of the response
+	Error    string        `yaml:"error,omitempty"`    // Error message to fail with instead
+	Status   int           `yaml:"status,omitempty"`   // HTTP status code to fail with instead, e.g. 429
+	Latency  time.Duration `yaml:"latency,omitempty"`  // Delay of the response, overriding the engine config
+	// Tools to call first if tools are given, the response is replied once their results are sent back
+	ToolCalls []MockToolCall `yaml:"tool_calls,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// MockToolCall is a canned call of a tool
+type MockToolCall struct {
+	Name      string         `yaml:"name"`
+	Arguments map[string]any `yaml:"arguments,omitempty"`
+}
+
+// Mock is an offline engine for testing. It echoes the last user message unless a canned response
+// of the fixture matches.
+type Mock struct {
+	model     string
+	latency   time.Duration
+	timeout   time.Duration // Time limit of a query, none if zero
+	models    []string
+	responses []MockResponseDef
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "mock",
+		DefaultModel: "echo",
+		Capabilities: Capabilities{ListModels: true, Tools: true},
+		New:          constructor(NewMock),
+	})
+}
+
+func NewMock(model string, cfg config.LLMEngineConfig) (*Mock, error) {
+	if model == "" {
+		model = cfg.Model
+	}
+	mock := &Mock{model: model, latency: cfg.Latency, timeout: cfg.Timeout, models: MockModels}
+	if cfg.Fixture == "" {
+		return mock, nil
+	}
+
+	fixtureFile, err := config.ExpandTilde(cfg.Fixture)
+	if err != nil {
+		return nil, err
+	}
+	fixture, err := utils.LoadConfig[MockFixture](fixtureFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mock fixture %s: %v", cfg.Fixture, err)
+	}
+	if err := mock.setFixture(fixture); err != nil {
+		return nil, err
+	}
+	return mock, nil
+}
+
+func (m *Mock) setFixture(fixture *MockFixture) error {
+	for i := range fixture.Responses {
+		pattern, err := regexp.Compile(fixture.Responses[i].Match)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of mock response #%d: %v", i+1, err)
+		}
+		fixture.Responses[i].pattern = pattern
+	}
+	m.responses = fixture.Responses
+	if len(fixture.Models) > 0 {
+		m.models = fixture.Models
+	}
+	return nil
+}
+
+// ChatStream streams the response word by word. The simulated latency is cut short once the context of the
+// query is done. A canned response calling tools returns the tool calls unless the last message is a tool result.
+func (m *Mock) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	ctx, cancel := callOpts.contextWithin(m.timeout)
+	defer cancel()
+
+	prompt := lastUserMessage(messages)
+	def := m.match(prompt)
+	if def == nil {
+		def = &MockResponseDef{Response: prompt}
+	}
+	latency := m.latency
+	if def.Latency > 0 {
+		latency = def.Latency
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Mock query failed: %w", ctx.Err())
+	}
+
+	if def.Status != 0 {
+		return nil, fmt.Errorf("Mock query failed: %w", &utils.StatusError{StatusCode: def.Status})
+	}
+	if def.Error != "" {
+		return nil, fmt.Errorf("Mock query failed: %s", def.Error)
+	}
+
+	if len(def.ToolCalls) > 0 && len(callOpts.Tools) > 0 && (len(messages) == 0 || messages[len(messages)-1].Role != RoleTool) {
+		return m.callTools(messages, def.ToolCalls)
+	}
+
+	content := def.Response
+	if onChunk != nil {
+		for _, chunk := range strings.SplitAfter(content, " ") {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("Mock query failed: %w", err)
+			}
+			if err := onChunk(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Response{Content: content, Usage: EstimateUsage(messages, content)}, nil
+}
+
+// callTools returns the canned tool calls instead of a response
+func (m *Mock) callTools(messages []Message, calls []MockToolCall) (*Response, error) {
+	response := &Response{}
+	for i, call := range calls {
+		arguments, err := json.Marshal(utils.ToJSONCompatible(call.Arguments))
+		if err != nil {
+			return nil, fmt.Errorf("Mock query failed: invalid arguments of tool %s: %v", call.Name, err)
+		}
+		response.ToolCalls = append(response.ToolCalls, NewToolCall(fmt.Sprintf("call_%d", i+1), call.Name, string(arguments)))
+	}
+	response.Usage = EstimateUsage(messages, "")
+	return response, nil
+}
+
+// match returns the first canned response matching the prompt, or nil if none
+func (m *Mock) match(prompt string) *MockResponseDef {
+	for i := range m.responses {
+		if m.responses[i].pattern.MatchString(prompt) {
+			return &m.responses[i]
+		}
+	}
+	return nil
+}
+
+func (m *Mock) ListAllModels(options ...CallOption) ([]string, error) {
+	return m.models, nil
+}
+
+func lastUserMessage(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
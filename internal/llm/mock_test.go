@@ -0,0 +1,162 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+const mockFixture = `models: [tiny, huge]
+responses:
+  - match: "(?i)^hello"
+    response: "Hi there, how can I help?"
+  - match: "busy"
+    status: 429
+  - match: "broken"
+    error: "model crashed"
+  - match: "slow"
+    response: "finally"
+    latency: 20ms
+  - match: "files"
+    response: "Found them"
+    tool_calls:
+      - name: list_directory
+        arguments: {path: .}
+`
+
+func newMock(t *testing.T) *testee.Mock {
+	fixtureFile := filepath.Join(t.TempDir(), "fixture.yaml")
+	assert.NoError(t, os.WriteFile(fixtureFile, []byte(mockFixture), 0o644))
+
+	mock, err := testee.NewMock("", config.LLMEngineConfig{Model: "tiny", Fixture: fixtureFile})
+	assert.NoError(t, err)
+	return mock
+}
+
+func TestMock(t *testing.T) {
+	t.Run("Echo", func(t *testing.T) {
+		mock, err := testee.NewMock("echo", config.LLMEngineConfig{})
+		assert.NoError(t, err)
+
+		response, err := testee.Chat(mock, []testee.Message{
+			{Role: testee.RoleSystem, Content: "be brief"},
+			{Role: testee.RoleUser, Content: "repeat after me"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "repeat after me", response.Content)
+		assert.True(t, response.Usage.Estimated)
+
+		models, err := mock.ListAllModels()
+		assert.NoError(t, err)
+		assert.Equal(t, testee.MockModels, models)
+	})
+
+	t.Run("CannedResponse", func(t *testing.T) {
+		var chunks []string
+		response, err := newMock(t).ChatStream([]testee.Message{{Role: testee.RoleUser, Content: "Hello mock"}}, func(chunk string) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "Hi there, how can I help?", response.Content)
+		assert.Equal(t, []string{"Hi ", "there, ", "how ", "can ", "I ", "help?"}, chunks)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		mock := newMock(t)
+
+		_, err := testee.Query(mock, "too busy")
+		var statusErr *utils.StatusError
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, 429, statusErr.StatusCode)
+		assert.True(t, testee.IsRetryable(err))
+
+		_, err = testee.Query(mock, "broken prompt")
+		assert.EqualError(t, err, "Mock query failed: model crashed")
+		assert.False(t, testee.IsRetryable(err))
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		start := time.Now()
+		response, err := testee.Query(newMock(t), "slow one")
+		assert.NoError(t, err)
+		assert.Equal(t, "finally", response.Content)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		mock, err := testee.NewMock("", config.LLMEngineConfig{Latency: time.Minute, Timeout: 10 * time.Millisecond})
+		assert.NoError(t, err)
+
+		_, err = testee.Query(mock, "too slow")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.True(t, testee.IsRetryable(err))
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		mock, err := testee.NewMock("", config.LLMEngineConfig{Latency: time.Minute})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = testee.Query(mock, "never mind", testee.WithContext(ctx))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.False(t, testee.IsRetryable(err))
+	})
+
+	t.Run("ToolCalls", func(t *testing.T) {
+		mock := newMock(t)
+		messages := []testee.Message{{Role: testee.RoleUser, Content: "list the files"}}
+
+		response, err := testee.Chat(mock, messages, testee.WithTools(testee.Tool{Name: "list_directory"}))
+		assert.NoError(t, err)
+		assert.Empty(t, response.Content)
+		assert.Equal(t, []testee.ToolCall{testee.NewToolCall("call_1", "list_directory", `{"path":"."}`)}, response.ToolCalls)
+
+		messages = append(messages,
+			testee.Message{Role: testee.RoleAssistant, ToolCalls: response.ToolCalls},
+			testee.Message{Role: testee.RoleTool, Content: "go.mod", ToolCallID: "call_1"},
+		)
+		response, err = testee.Chat(mock, messages, testee.WithTools(testee.Tool{Name: "list_directory"}))
+		assert.NoError(t, err)
+		assert.Equal(t, "Found them", response.Content)
+		assert.Empty(t, response.ToolCalls)
+
+		// no tools given
+		response, err = testee.Query(mock, "list the files")
+		assert.NoError(t, err)
+		assert.Equal(t, "Found them", response.Content)
+	})
+
+	t.Run("Models", func(t *testing.T) {
+		models, err := newMock(t).ListAllModels()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"tiny", "huge"}, models)
+	})
+
+	t.Run("InvalidFixture", func(t *testing.T) {
+		_, err := testee.NewMock("", config.LLMEngineConfig{Fixture: filepath.Join(t.TempDir(), "missing.yaml")})
+		assert.Error(t, err)
+	})
+}
+
+func TestNewEngine_Mock(t *testing.T) {
+	cfg := &config.Config{LLMEngines: map[string]config.LLMEngineConfig{"mock": {}}}
+
+	engine, err := testee.NewEngine("mock", "", cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &testee.Mock{}, engine)
+
+	response, err := testee.Query(engine, "ping")
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", response.Content)
+}
@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"github.com/robinmin/askllm/internal/config"
+)
+
+// ModelInfo is the metadata of a model, either listed by the provider or from the model catalog. Zero values
+// mean unknown.
+type ModelInfo struct {
+	Engine          string             `yaml:"engine"`                      // Name or kind of the engine
+	ID              string             `yaml:"id"`                          // Name of the model
+	Description     string             `yaml:"description,omitempty"`       // Human readable description
+	ContextWindow   int                `yaml:"context_window,omitempty"`    // Maximum number of input tokens
+	MaxOutputTokens int                `yaml:"max_output_tokens,omitempty"` // Maximum number of generated tokens
+	Modalities      []string           `yaml:"modalities,omitempty"`        // Input modalities, e.g. text, image or audio
+	Tools           bool               `yaml:"tools,omitempty"`             // Supports tool calling
+	JSONMode        bool               `yaml:"json_mode,omitempty"`         // Supports the JSON mode
+	Pricing         *config.ModelPrice `yaml:"pricing,omitempty"`           // Price in USD per million tokens
+	Deprecated      bool               `yaml:"deprecated,omitempty"`        // Deprecated or retired by the provider
+	Listed          bool               `yaml:"-"`                           // Listed by the provider rather than the catalog only
+}
+
+// ModelDescriber is implemented by the engines listing their models with metadata
+type ModelDescriber interface {
+	DescribeModels(options ...CallOption) ([]ModelInfo, error) // Only the context of the options applies
+}
+
+// DescribeModels lists the models of the engine with the metadata reported by the provider, or with their
+// names only if the engine does not report any
+func DescribeModels(engine Engine, options ...CallOption) ([]ModelInfo, error) {
+	if describer, ok := engine.(ModelDescriber); ok {
+		return describer.DescribeModels(options...)
+	}
+
+	names, err := engine.ListAllModels(options...)
+	if err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, 0, len(names))
+	for _, name := range names {
+		models = append(models, ModelInfo{ID: name, Listed: true})
+	}
+	return models, nil
+}
@@ -1,12 +1,15 @@
 package llm
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
-	// "time"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/robinmin/askllm/internal/config"
@@ -16,54 +19,81 @@ import (
 	"github.com/robinmin/askllm/pkg/utils"
 )
 
+const (
+	defaultOllamaURL        = "http://127.0.0.1:11434"
+	defaultOllamaLibraryURL = "https://ollama.com/library"
+)
+
 type Ollama struct {
 	model    string
 	llm      *ollama.LLM
-	context  context.Context
+	timeout  time.Duration // Time limit of a query, none if zero
 	chatURL  string
-	modelURL string
-	models   []string // List of all available models
+	baseURL  string                  // Base URL of the local API
+	modelURL string                  // URL of the remote model library
+	models   []string                // List of all available models
+	params   config.GenerationParams // Generation parameters of the engine config
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "ollama",
+		DefaultModel: "gemma2",
+		Capabilities: Capabilities{JSONMode: true, ListModels: true},
+		New:          constructor(NewOllama),
+	})
 }
 
 func NewOllama(model string, cfg config.LLMEngineConfig) (*Ollama, error) {
 	var err error
 	var llm *ollama.LLM
 
-	ctx := context.Background()
 	if model == "" {
 		model = cfg.Model
 	}
 	if cfg.BaseURL != "" {
-		llm, err = ollama.New(ollama.WithModel(model), ollama.WithServerURL(cfg.BaseURL))
+		llm, err = ollama.New(ollama.WithModel(model), ollama.WithServerURL(cfg.BaseURL), ollama.WithHTTPClient(utils.HTTPClient()))
 	} else {
-		llm, err = ollama.New(ollama.WithModel(model))
+		llm, err = ollama.New(ollama.WithModel(model), ollama.WithHTTPClient(utils.HTTPClient()))
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Ollama: %v", err)
 	}
 
+	baseURL, modelURL := cfg.BaseURL, cfg.ExtraURL
+	if baseURL == "" {
+		baseURL = defaultOllamaURL
+	}
+	if modelURL == "" {
+		modelURL = defaultOllamaLibraryURL
+	}
+
 	return &Ollama{
 		model:    model,
 		llm:      llm,
-		context:  ctx,
+		timeout:  cfg.Timeout,
 		chatURL:  cfg.BaseURL + "/chat/completions",
-		modelURL: cfg.ExtraURL,
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		modelURL: modelURL,
+		params:   cfg.GenerationParams,
 	}, nil
 }
 
-func (o *Ollama) Query(prompt string) (string, error) {
-	result, err := llms.GenerateFromSinglePrompt(
-		o.context, o.llm, prompt,
-		llms.WithTemperature(0.2),
+func (o *Ollama) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	ctx, cancel := callOpts.contextWithin(o.timeout)
+	defer cancel()
+	result, err := generateContent(
+		ctx, o.llm, messages, onChunk, callOpts, o.params,
 		llms.WithModel(o.model),
 	)
 	if err != nil {
-		return "", fmt.Errorf("Ollama query failed: %v", err)
+		return nil, fmt.Errorf("Ollama query failed: %w", err)
 	}
 	return result, nil
 }
 
-// OllamaModel represents information about an Ollama model.
+// OllamaModel represents information about a model of the remote Ollama library.
 type OllamaModel struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
@@ -72,7 +102,8 @@ type OllamaModel struct {
 	Updated     string `json:"updated"`
 }
 
-func (o *Ollama) ListAllModelsCore() ([]OllamaModel, error) {
+// ListRemoteModels scrapes the models of the remote Ollama library, installed or not
+func (o *Ollama) ListRemoteModels(ctx context.Context) ([]OllamaModel, error) {
 	// Fetch the webpage content
 	// resp, err := http.Get(o.modelURL)
 	// if err != nil {
@@ -85,7 +116,7 @@ func (o *Ollama) ListAllModelsCore() ([]OllamaModel, error) {
 	// if resp.StatusCode != http.StatusOK {
 	// 	return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	// }
-	respBody, err := utils.APIRequestCore(http.MethodGet, o.modelURL, nil, nil)
+	respBody, err := utils.APIRequestCore(ctx, http.MethodGet, o.modelURL, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch webpage: %v", err)
 	}
@@ -138,12 +169,138 @@ func (o *Ollama) ListAllModelsCore() ([]OllamaModel, error) {
 	return models, nil
 }
 
-func (o *Ollama) ListAllModels() ([]string, error) {
+// OllamaModelDetails are the details of an installed model
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaLocalModel is a model installed on the Ollama server
+type OllamaLocalModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt time.Time          `json:"modified_at"`
+	Size       int64              `json:"size"` // Size in bytes
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type ollamaTagsResponse struct {
+	Models []OllamaLocalModel `json:"models"`
+}
+
+// OllamaModelInfo is the information of an installed model
+type OllamaModelInfo struct {
+	Modelfile  string             `json:"modelfile"`
+	Parameters string             `json:"parameters"`
+	Template   string             `json:"template"`
+	Details    OllamaModelDetails `json:"details"`
+	ModelInfo  map[string]any     `json:"model_info"`
+}
+
+// OllamaPullProgress is the progress of pulling a model
+type OllamaPullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`     // Size in bytes of the layer being downloaded
+	Completed int64  `json:"completed,omitempty"` // Bytes downloaded of the layer
+	Error     string `json:"error,omitempty"`
+}
+
+type ollamaModelRequest struct {
+	Model  string `json:"model"`
+	Stream *bool  `json:"stream,omitempty"`
+}
+
+// ListLocalModels lists the models installed on the Ollama server
+func (o *Ollama) ListLocalModels(ctx context.Context) ([]OllamaLocalModel, error) {
+	response, err := utils.APIGet[ollamaTagsResponse](ctx, o.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching Ollama models: %w", err)
+	}
+	return response.Models, nil
+}
+
+// ShowModel returns the information of an installed model
+func (o *Ollama) ShowModel(ctx context.Context, name string) (*OllamaModelInfo, error) {
+	info, err := utils.APIPost[ollamaModelRequest, OllamaModelInfo](ctx, o.baseURL+"/api/show", ollamaModelRequest{Model: name}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error showing Ollama model %s: %w", name, err)
+	}
+	return info, nil
+}
+
+// DeleteModel removes an installed model from the Ollama server
+func (o *Ollama) DeleteModel(ctx context.Context, name string) error {
+	body, err := json.Marshal(ollamaModelRequest{Model: name})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	if _, err := utils.APIRequestCore(ctx, http.MethodDelete, o.baseURL+"/api/delete", body, headers); err != nil {
+		return fmt.Errorf("error deleting Ollama model %s: %w", name, err)
+	}
+	o.models = nil
+	return nil
+}
+
+// PullModel downloads the model from the Ollama library into the server, reporting the progress into
+// onProgress if it is not nil. A pull can take minutes, hence it is not bound by the timeout of the API client,
+// but aborted once the context is done.
+func (o *Ollama) PullModel(ctx context.Context, name string, onProgress func(OllamaPullProgress)) error {
+	stream := true
+	body, err := json.Marshal(ollamaModelRequest{Model: name, Stream: &stream})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := utils.HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error pulling Ollama model %s: %w", name, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("error pulling Ollama model %s: %w", name, &utils.StatusError{StatusCode: resp.StatusCode})
+	}
+
+	// the progress is reported as one JSON document per line
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var progress OllamaPullProgress
+		if err := json.Unmarshal(scanner.Bytes(), &progress); err != nil {
+			return fmt.Errorf("error unmarshaling pull progress: %v", err)
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("error pulling Ollama model %s: %s", name, progress.Error)
+		}
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading pull progress: %v", err)
+	}
+	o.models = nil
+	return nil
+}
+
+// ListAllModels lists the names of the models installed on the Ollama server
+func (o *Ollama) ListAllModels(options ...CallOption) ([]string, error) {
 	if len(o.models) > 0 {
 		return o.models, nil
 	}
 
-	models, err := o.ListAllModelsCore()
+	models, err := o.ListLocalModels(NewCallOptions(options...).ctx())
 	if err != nil {
 		return nil, err
 	}
@@ -153,3 +310,21 @@ func (o *Ollama) ListAllModels() ([]string, error) {
 	}
 	return o.models, nil
 }
+
+// DescribeModels lists the installed models, described by their family, size and quantization
+func (o *Ollama) DescribeModels(options ...CallOption) ([]ModelInfo, error) {
+	models, err := o.ListLocalModels(NewCallOptions(options...).ctx())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ModelInfo, 0, len(models))
+	for _, model := range models {
+		description := strings.TrimSpace(fmt.Sprintf("%s %s %s", model.Details.Family, model.Details.ParameterSize, model.Details.QuantizationLevel))
+		result = append(result, ModelInfo{
+			ID:          model.Name,
+			Description: fmt.Sprintf("%s (%.1f GB)", description, float64(model.Size)/(1<<30)),
+			Listed:      true,
+		})
+	}
+	return result, nil
+}
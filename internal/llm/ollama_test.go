@@ -0,0 +1,91 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+)
+
+func newOllamaServer(t *testing.T) (*httptest.Server, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		calls = append(calls, fmt.Sprintf("%s %s %v", r.Method, r.URL.Path, body["model"]))
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/tags":
+			_, _ = fmt.Fprint(w, `{"models":[{"name":"gemma2:latest","model":"gemma2:latest","modified_at":"2024-07-15T10:00:00Z","size":5443152417,
+				"digest":"ff02c3702f32","details":{"format":"gguf","family":"gemma2","families":["gemma2"],"parameter_size":"9.2B","quantization_level":"Q4_0"}}]}`)
+		case "POST /api/show":
+			_, _ = fmt.Fprint(w, `{"parameters":"stop \"<end_of_turn>\"","template":"{{ .Prompt }}","details":{"format":"gguf","family":"gemma2","parameter_size":"9.2B","quantization_level":"Q4_0"}}`)
+		case "DELETE /api/delete":
+			w.WriteHeader(http.StatusOK)
+		case "POST /api/pull":
+			if body["model"] == "missing" {
+				_, _ = fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+				return
+			}
+			_, _ = fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+			_, _ = fmt.Fprintln(w, `{"status":"pulling ff02c3702f32","digest":"sha256:ff02c3702f32","total":100,"completed":50}`)
+			_, _ = fmt.Fprintln(w, `{"status":"pulling ff02c3702f32","digest":"sha256:ff02c3702f32","total":100,"completed":100}`)
+			_, _ = fmt.Fprintln(w, `{"status":"success"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestOllama_LocalAPI(t *testing.T) {
+	server, calls := newOllamaServer(t)
+	engine, err := testee.NewOllama("gemma2", config.LLMEngineConfig{BaseURL: server.URL})
+	assert.NoError(t, err)
+
+	t.Run("ListLocalModels", func(t *testing.T) {
+		models, err := engine.ListLocalModels(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, models, 1)
+		assert.Equal(t, "gemma2:latest", models[0].Name)
+		assert.Equal(t, int64(5443152417), models[0].Size)
+		assert.Equal(t, "Q4_0", models[0].Details.QuantizationLevel)
+		assert.Equal(t, 2024, models[0].ModifiedAt.Year())
+
+		names, err := engine.ListAllModels()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"gemma2:latest"}, names)
+	})
+
+	t.Run("ShowModel", func(t *testing.T) {
+		info, err := engine.ShowModel(context.Background(), "gemma2")
+		assert.NoError(t, err)
+		assert.Equal(t, "9.2B", info.Details.ParameterSize)
+		assert.Equal(t, "{{ .Prompt }}", info.Template)
+	})
+
+	t.Run("PullModel", func(t *testing.T) {
+		var statuses []string
+		err := engine.PullModel(context.Background(), "llama3", func(progress testee.OllamaPullProgress) {
+			statuses = append(statuses, fmt.Sprintf("%s %d/%d", progress.Status, progress.Completed, progress.Total))
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"pulling manifest 0/0", "pulling ff02c3702f32 50/100", "pulling ff02c3702f32 100/100", "success 0/0"}, statuses)
+
+		err = engine.PullModel(context.Background(), "missing", nil)
+		assert.ErrorContains(t, err, "file does not exist")
+	})
+
+	t.Run("DeleteModel", func(t *testing.T) {
+		assert.NoError(t, engine.DeleteModel(context.Background(), "gemma2"))
+		assert.Contains(t, *calls, "DELETE /api/delete gemma2")
+	})
+}
@@ -0,0 +1,352 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+const (
+	defaultChatPath   = "/chat/completions"
+	defaultModelsPath = "/models"
+	defaultAuthHeader = "Authorization"
+	defaultAuthScheme = "Bearer"
+)
+
+// OpenAICompatible is an engine of any provider implementing the chat completions API of OpenAI, e.g.
+// DeepSeek, Mistral, OpenRouter, Together, vLLM or Azure OpenAI. It is configured entirely by the engine
+// config: base URL, chat and models paths, auth header, extra headers and query parameters.
+type OpenAICompatible struct {
+	label      string // Name of the provider in error messages
+	model      string
+	timeout    time.Duration // Time limit of a query, none if zero
+	apiKey     string
+	authHeader string
+	authScheme string
+	headers    map[string]string
+	chatURL    string
+	modelURL   string
+	models     []string                // List of all available models
+	params     config.GenerationParams // Generation parameters of the engine config
+
+	// headers and query parameters of the config carrying credentials, to redact from the logs and cassettes
+	redactHeaders []string
+	redactParams  []string
+}
+
+type chatCompletionRequest struct {
+	Messages       []Message       `json:"messages"`
+	Model          string          `json:"model"`
+	Stream         bool            `json:"stream,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	TopP           *float64        `json:"top_p,omitempty"`
+	MaxTokens      *int            `json:"max_tokens,omitempty"`
+	Stop           []string        `json:"stop,omitempty"`
+	Seed           *int            `json:"seed,omitempty"`
+	Tools          []toolDef       `json:"tools,omitempty"`
+}
+
+type responseFormat struct {
+	Type string `json:"type"`
+}
+
+// toolDef is the definition of a tool in the chat completions API
+type toolDef struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+func toToolDefs(tools []Tool) []toolDef {
+	defs := make([]toolDef, 0, len(tools))
+	for _, tool := range tools {
+		def := toolDef{Type: "function"}
+		def.Function.Name = tool.Name
+		def.Function.Description = tool.Description
+		def.Function.Parameters = tool.Parameters
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+type chatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *chatCompletionUsage `json:"usage,omitempty"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *chatCompletionUsage `json:"usage,omitempty"`
+	XGroq *struct {
+		Usage *chatCompletionUsage `json:"usage,omitempty"`
+	} `json:"x_groq,omitempty"` // Groq reports the usage of a stream in the last chunk
+}
+
+// toUsage converts the reported usage, or estimates it if not reported
+func (u *chatCompletionUsage) toUsage(messages []Message, content string) Usage {
+	if u == nil {
+		return EstimateUsage(messages, content)
+	}
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "openai",
+		DefaultModel: "gpt-4o-mini",
+		Capabilities: Capabilities{JSONMode: true, ListModels: true, Tools: true},
+		New:          constructor(NewOpenAICompatible),
+	})
+}
+
+func NewOpenAICompatible(model string, cfg config.LLMEngineConfig) (*OpenAICompatible, error) {
+	return newOpenAICompatible("OpenAI-compatible", model, cfg)
+}
+
+func newOpenAICompatible(label string, model string, cfg config.LLMEngineConfig) (*OpenAICompatible, error) {
+	if model == "" {
+		model = cfg.Model
+	}
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("failed to initialize %s: no base_url configured", label)
+	}
+
+	chatPath, modelsPath := cfg.ChatPath, cfg.ModelsPath
+	if chatPath == "" {
+		chatPath = defaultChatPath
+	}
+	if modelsPath == "" {
+		modelsPath = defaultModelsPath
+	}
+	// the scheme only makes sense for the Authorization header, e.g. Azure expects the bare key in api-key
+	authHeader, authScheme := cfg.AuthHeader, cfg.AuthScheme
+	if authHeader == "" {
+		authHeader = defaultAuthHeader
+	}
+	if authScheme == "" && http.CanonicalHeaderKey(authHeader) == defaultAuthHeader {
+		authScheme = defaultAuthScheme
+	}
+
+	headers := map[string]string{}
+	if cfg.OrgnizationId != "" {
+		headers["OpenAI-Organization"] = cfg.OrgnizationId
+	}
+	for key, value := range cfg.Headers {
+		headers[key] = value
+	}
+
+	// keep the credentials of the custom headers and query parameters out of the logs and recorded cassettes
+	redactHeaders := []string{authHeader}
+	for key := range cfg.Headers {
+		redactHeaders = append(redactHeaders, key)
+	}
+	redactParams := make([]string, 0, len(cfg.QueryParams))
+	for key := range cfg.QueryParams {
+		redactParams = append(redactParams, key)
+	}
+
+	return &OpenAICompatible{
+		label:      label,
+		model:      model,
+		timeout:    cfg.Timeout,
+		apiKey:     cfg.APIKey,
+		authHeader: authHeader,
+		authScheme: authScheme,
+		headers:    headers,
+		chatURL:    endpoint(cfg.BaseURL, chatPath, cfg.QueryParams),
+		modelURL:   endpoint(cfg.BaseURL, modelsPath, cfg.QueryParams),
+		params:     cfg.GenerationParams,
+
+		redactHeaders: redactHeaders,
+		redactParams:  redactParams,
+	}, nil
+}
+
+// redacted returns a copy of ctx redacting the credentials of the config from the requests made with it
+func (o *OpenAICompatible) redacted(ctx context.Context) context.Context {
+	return utils.WithRedaction(ctx, o.redactHeaders, o.redactParams)
+}
+
+// endpoint joins the base URL and the path, and appends the query parameters
+func endpoint(baseURL string, path string, queryParams map[string]string) string {
+	result := strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(path, "/")
+	if len(queryParams) == 0 {
+		return result
+	}
+
+	query := url.Values{}
+	for key, value := range queryParams {
+		query.Set(key, value)
+	}
+	separator := "?"
+	if strings.Contains(result, "?") {
+		separator = "&"
+	}
+	return result + separator + query.Encode()
+}
+
+// requestHeaders returns the headers of every request, including the auth header if an API key is configured
+func (o *OpenAICompatible) requestHeaders() map[string]string {
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, value := range o.headers {
+		headers[key] = value
+	}
+	if o.apiKey != "" {
+		headers[o.authHeader] = strings.TrimSpace(o.authScheme + " " + o.apiKey)
+	}
+	return headers
+}
+
+func (o *OpenAICompatible) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	params := callOpts.Params(o.params)
+	reqBody := chatCompletionRequest{
+		Messages:    messages,
+		Model:       o.model,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		MaxTokens:   params.MaxTokens,
+		Stop:        params.Stop,
+		Seed:        params.Seed,
+	}
+	if callOpts.JSONMode {
+		reqBody.ResponseFormat = &responseFormat{Type: "json_object"}
+	}
+	if len(callOpts.Tools) > 0 {
+		reqBody.Tools = toToolDefs(callOpts.Tools)
+	}
+
+	ctx, cancel := callOpts.contextWithin(o.timeout)
+	defer cancel()
+	ctx = o.redacted(ctx)
+	headers := o.requestHeaders()
+	// the tool calls are not streamed, the response is passed to onChunk at once instead
+	if onChunk != nil && len(reqBody.Tools) == 0 {
+		return o.stream(ctx, reqBody, headers, onChunk)
+	}
+
+	chatResp, err := utils.APIPost[chatCompletionRequest, chatCompletionResponse](ctx, o.chatURL, reqBody, headers)
+	if err != nil {
+		return nil, fmt.Errorf("%s query failed: %w", o.label, err)
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in response")
+	}
+
+	message := chatResp.Choices[0].Message
+	if onChunk != nil && message.Content != "" {
+		if err := onChunk(message.Content); err != nil {
+			return nil, err
+		}
+	}
+	return &Response{Content: message.Content, ToolCalls: message.ToolCalls, Usage: chatResp.Usage.toUsage(messages, message.Content)}, nil
+}
+
+func (o *OpenAICompatible) stream(ctx context.Context, reqBody chatCompletionRequest, headers map[string]string, onChunk StreamFunc) (*Response, error) {
+	var result strings.Builder
+	var usage *chatCompletionUsage
+
+	reqBody.Stream = true
+	err := utils.APIPostStream(ctx, o.chatURL, reqBody, headers, func(data []byte) error {
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("error unmarshaling stream chunk: %v", err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		} else if chunk.XGroq != nil && chunk.XGroq.Usage != nil {
+			usage = chunk.XGroq.Usage
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			return nil
+		}
+		result.WriteString(chunk.Choices[0].Delta.Content)
+		return onChunk(chunk.Choices[0].Delta.Content)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s stream failed: %w", o.label, err)
+	}
+	return &Response{Content: result.String(), Usage: usage.toUsage(reqBody.Messages, result.String())}, nil
+}
+
+// OpenAIModel is a model of the models API. The fields other than the ID are optional.
+type OpenAIModel struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Created       int64  `json:"created"`
+	OwnedBy       string `json:"owned_by"`
+	Active        bool   `json:"active"`         // So far, only reported by groq
+	ContextWindow int    `json:"context_window"` // So far, only reported by groq
+}
+
+type OpenAIModelListResponse struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+func (o *OpenAICompatible) ListAllModelsCore(ctx context.Context) ([]OpenAIModel, error) {
+	response, err := utils.APIGet[OpenAIModelListResponse](o.redacted(ctx), o.modelURL, o.requestHeaders())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching models: %w", err)
+	}
+
+	if response == nil {
+		return nil, fmt.Errorf("received nil response")
+	}
+
+	return response.Data, nil
+}
+
+func (o *OpenAICompatible) ListAllModels(options ...CallOption) ([]string, error) {
+	if len(o.models) > 0 {
+		return o.models, nil
+	}
+	models, err := o.ListAllModelsCore(NewCallOptions(options...).ctx())
+	if err != nil {
+		return nil, err
+	}
+
+	o.models = []string{}
+	for _, model := range models {
+		o.models = append(o.models, model.ID)
+	}
+	return o.models, nil
+}
+
+func (o *OpenAICompatible) DescribeModels(options ...CallOption) ([]ModelInfo, error) {
+	models, err := o.ListAllModelsCore(NewCallOptions(options...).ctx())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ModelInfo, 0, len(models))
+	for _, model := range models {
+		result = append(result, ModelInfo{ID: model.ID, ContextWindow: model.ContextWindow, Listed: true})
+	}
+	return result, nil
+}
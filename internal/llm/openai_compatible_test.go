@@ -0,0 +1,170 @@
+package llm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+type capturedRequest struct {
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]any
+}
+
+// newOpenAIServer serves the chat completions and models APIs at the given paths, and captures the requests
+func newOpenAIServer(t *testing.T, chatPath, modelsPath string) (*httptest.Server, *[]capturedRequest) {
+	var captured []capturedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := capturedRequest{Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header}
+		_ = json.NewDecoder(r.Body).Decode(&req.Body)
+		captured = append(captured, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case chatPath:
+			_, _ = fmt.Fprint(w, `{"choices":[{"message":{"content":"pong"}}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`)
+		case modelsPath:
+			_, _ = fmt.Fprint(w, `{"object":"list","data":[{"id":"deepseek-chat"},{"id":"deepseek-coder"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &captured
+}
+
+func TestOpenAICompatible(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		server, captured := newOpenAIServer(t, "/v1/chat/completions", "/v1/models")
+		engine, err := testee.NewOpenAICompatible("deepseek-chat", config.LLMEngineConfig{APIKey: "key", BaseURL: server.URL + "/v1/"})
+		assert.NoError(t, err)
+
+		response, err := testee.Query(engine, "ping", testee.WithJSONMode())
+		assert.NoError(t, err)
+		assert.Equal(t, "pong", response.Content)
+		assert.Equal(t, testee.Usage{PromptTokens: 3, CompletionTokens: 1}, response.Usage)
+
+		models, err := engine.ListAllModels()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"deepseek-chat", "deepseek-coder"}, models)
+
+		req := (*captured)[0]
+		assert.Equal(t, "Bearer key", req.Header.Get("Authorization"))
+		assert.Equal(t, "deepseek-chat", req.Body["model"])
+		assert.Equal(t, map[string]any{"type": "json_object"}, req.Body["response_format"])
+		assert.Equal(t, "Bearer key", (*captured)[1].Header.Get("Authorization"))
+	})
+
+	t.Run("AzureStyle", func(t *testing.T) {
+		server, captured := newOpenAIServer(t, "/openai/deployments/gpt4o/chat/completions", "/openai/models")
+		cfg := config.LLMEngineConfig{
+			Kind:        "openai",
+			APIKey:      "azure-key",
+			BaseURL:     server.URL + "/openai",
+			ChatPath:    "deployments/gpt4o/chat/completions",
+			AuthHeader:  "api-key",
+			Headers:     map[string]string{"X-Title": "askllm"},
+			QueryParams: map[string]string{"api-version": "2024-06-01"},
+		}
+		engine, err := testee.NewEngine("azure", "gpt-4o", &config.Config{LLMEngines: map[string]config.LLMEngineConfig{"azure": cfg}})
+		assert.NoError(t, err)
+
+		response, err := testee.Query(engine, "ping")
+		assert.NoError(t, err)
+		assert.Equal(t, "pong", response.Content)
+
+		req := (*captured)[0]
+		assert.Equal(t, "api-version=2024-06-01", req.Query)
+		assert.Equal(t, "azure-key", req.Header.Get("Api-Key"))
+		assert.Empty(t, req.Header.Get("Authorization"))
+		assert.Equal(t, "askllm", req.Header.Get("X-Title"))
+	})
+
+	t.Run("CustomScheme", func(t *testing.T) {
+		server, captured := newOpenAIServer(t, "/chat", "/models")
+		engine, err := testee.NewOpenAICompatible("m", config.LLMEngineConfig{APIKey: "key", BaseURL: server.URL, ChatPath: "/chat", AuthScheme: "Token"})
+		assert.NoError(t, err)
+
+		_, err = testee.Query(engine, "ping")
+		assert.NoError(t, err)
+		assert.Equal(t, "Token key", (*captured)[0].Header.Get("Authorization"))
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		var body map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"choices":[{"message":{"content":"","tool_calls":[{"id":"call_7","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"go.mod\"}"}}]}}]}`)
+		}))
+		defer server.Close()
+		engine, err := testee.NewOpenAICompatible("m", config.LLMEngineConfig{BaseURL: server.URL})
+		assert.NoError(t, err)
+
+		tool := testee.Tool{Name: "read_file", Description: "Read a file", Parameters: map[string]any{"type": "object"}}
+		var chunks []string
+		response, err := engine.ChatStream([]testee.Message{
+			{Role: testee.RoleUser, Content: "show go.mod"},
+			{Role: testee.RoleAssistant, ToolCalls: []testee.ToolCall{testee.NewToolCall("call_6", "list_directory", "{}")}},
+			{Role: testee.RoleTool, Content: "go.mod", ToolCallID: "call_6"},
+		}, func(chunk string) error {
+			chunks = append(chunks, chunk)
+			return nil
+		}, testee.WithTools(tool))
+		assert.NoError(t, err)
+		assert.Equal(t, []testee.ToolCall{testee.NewToolCall("call_7", "read_file", `{"path":"go.mod"}`)}, response.ToolCalls)
+		assert.Empty(t, chunks)
+
+		assert.Equal(t, []any{map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": "read_file", "description": "Read a file", "parameters": map[string]any{"type": "object"}},
+		}}, body["tools"])
+		assert.Nil(t, body["stream"])
+		messages := body["messages"].([]any)
+		assert.Equal(t, "call_6", messages[1].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)["id"])
+		assert.Equal(t, "call_6", messages[2].(map[string]any)["tool_call_id"])
+	})
+
+	t.Run("RecordCustomAuth", func(t *testing.T) {
+		server, _ := newOpenAIServer(t, "/chat/completions", "/models")
+		file := filepath.Join(t.TempDir(), "cassette.yaml")
+		recorder := utils.NewCassetteRecorder(file, nil)
+		utils.SetTransport(recorder)
+		defer utils.SetTransport(nil)
+
+		engine, err := testee.NewOpenAICompatible("m", config.LLMEngineConfig{
+			APIKey:      "secret-key",
+			BaseURL:     server.URL,
+			AuthHeader:  "X-Custom-Key",
+			Headers:     map[string]string{"X-Tenant": "secret-tenant"},
+			QueryParams: map[string]string{"token": "secret-token"},
+		})
+		assert.NoError(t, err)
+		_, err = testee.Query(engine, "ping")
+		assert.NoError(t, err)
+		assert.NoError(t, recorder.Save())
+
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+		assert.Contains(t, string(data), "X-Custom-Key: REDACTED")
+		assert.Contains(t, string(data), "token=REDACTED")
+	})
+
+	t.Run("NoBaseURL", func(t *testing.T) {
+		_, err := testee.NewOpenAICompatible("m", config.LLMEngineConfig{})
+		assert.Error(t, err)
+	})
+}
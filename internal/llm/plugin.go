@@ -0,0 +1,254 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/pkg/utils"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// pluginStopTimeout is the time for a plugin to exit once its stdin is closed
+const pluginStopTimeout = 5 * time.Second
+
+const (
+	PluginMethodChat       = "chat"
+	PluginMethodListModels = "list_models"
+
+	PluginTypeChunk  = "chunk"  // A chunk of a streaming response
+	PluginTypeResult = "result" // The final result of a request
+	PluginTypeError  = "error"  // The failure of a request
+)
+
+// PluginRequest is a request of the plugin protocol, written into the stdin of the plugin as one JSON line
+type PluginRequest struct {
+	ID       int                     `json:"id"`
+	Method   string                  `json:"method"` // One of chat or list_models
+	Model    string                  `json:"model,omitempty"`
+	Messages []Message               `json:"messages,omitempty"`
+	Stream   bool                    `json:"stream,omitempty"` // Whether chunks are expected before the result
+	JSONMode bool                    `json:"json_mode,omitempty"`
+	Params   config.GenerationParams `json:"params"`
+}
+
+// PluginResponse is a message of the plugin protocol, read from the stdout of the plugin as one JSON line.
+// A request is answered by any number of chunks, then either a result or an error.
+type PluginResponse struct {
+	ID      int      `json:"id"`
+	Type    string   `json:"type"`              // One of chunk, result or error
+	Content string   `json:"content,omitempty"` // Chunk or response content
+	Usage   *Usage   `json:"usage,omitempty"`   // Token usage of the result, estimated if absent
+	Models  []string `json:"models,omitempty"`  // Result of list_models
+	Error   string   `json:"error,omitempty"`   // Error message
+	Status  int      `json:"status,omitempty"`  // HTTP-like status code of the error, e.g. 429 to trigger fallback
+}
+
+// Plugin is an engine implemented by an external executable, which is launched on the first request and
+// kept running to serve the following ones. It speaks JSON lines over stdin/stdout, and its stderr is
+// passed through. API key and base URL of the engine config are passed in the ASKLLM_API_KEY and
+// ASKLLM_BASE_URL environment variables.
+type Plugin struct {
+	model   string
+	command []string
+	env     []string
+	params  config.GenerationParams
+	timeout time.Duration // Time limit of a query, none if zero
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+	nextID int
+}
+
+func init() {
+	Register(EngineKind{
+		Name:         "plugin",
+		Capabilities: Capabilities{JSONMode: true, ListModels: true},
+		New:          constructor(NewPlugin),
+	})
+}
+
+func NewPlugin(model string, cfg config.LLMEngineConfig) (*Plugin, error) {
+	if model == "" {
+		model = cfg.Model
+	}
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("failed to initialize Plugin: no command configured")
+	}
+	return &Plugin{
+		model:   model,
+		command: cfg.Command,
+		env:     []string{"ASKLLM_API_KEY=" + cfg.APIKey, "ASKLLM_BASE_URL=" + cfg.BaseURL},
+		params:  cfg.GenerationParams,
+		timeout: cfg.Timeout,
+	}, nil
+}
+
+func (p *Plugin) ChatStream(messages []Message, onChunk StreamFunc, options ...CallOption) (*Response, error) {
+	callOpts := NewCallOptions(options...)
+	ctx, cancel := callOpts.contextWithin(p.timeout)
+	defer cancel()
+	req := PluginRequest{
+		Method:   PluginMethodChat,
+		Model:    p.model,
+		Messages: messages,
+		Stream:   onChunk != nil,
+		JSONMode: callOpts.JSONMode,
+		Params:   callOpts.Params(p.params),
+	}
+
+	result, err := p.call(ctx, req, onChunk)
+	if err != nil {
+		return nil, fmt.Errorf("Plugin query failed: %w", err)
+	}
+	usage := EstimateUsage(messages, result.Content)
+	if result.Usage != nil {
+		usage = *result.Usage
+	}
+	return &Response{Content: result.Content, Usage: usage}, nil
+}
+
+func (p *Plugin) ListAllModels(options ...CallOption) ([]string, error) {
+	result, err := p.call(NewCallOptions(options...).ctx(), PluginRequest{Method: PluginMethodListModels}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching plugin models: %w", err)
+	}
+	return result.Models, nil
+}
+
+// Close stops the plugin process if it is running
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stop()
+}
+
+// call sends the request and reads the responses until its result or error. Requests are served one by one.
+// The plugin is killed if the context is done before the result, and launched again by the next request.
+func (p *Plugin) call(ctx context.Context, req PluginRequest, onChunk StreamFunc) (*PluginResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	process := p.cmd.Process
+	stopKill := context.AfterFunc(ctx, func() {
+		_ = process.Kill()
+	})
+	defer stopKill()
+	p.nextID++
+	req.ID = p.nextID
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		_ = p.stop()
+		return nil, fmt.Errorf("failed to write to plugin: %w", err)
+	}
+
+	for p.stdout.Scan() {
+		var resp PluginResponse
+		if err := json.Unmarshal(p.stdout.Bytes(), &resp); err != nil {
+			log.Warnf("Ignored invalid plugin output: %s", p.stdout.Text())
+			continue
+		}
+		if resp.ID != req.ID {
+			log.Warnf("Ignored plugin output of request #%d", resp.ID)
+			continue
+		}
+
+		switch resp.Type {
+		case PluginTypeChunk:
+			if onChunk != nil {
+				if err := onChunk(resp.Content); err != nil {
+					// the remaining output of the request is skipped by the next request
+					return nil, err
+				}
+			}
+		case PluginTypeResult:
+			return &resp, nil
+		case PluginTypeError:
+			if resp.Status != 0 {
+				return nil, fmt.Errorf("%s: %w", resp.Error, &utils.StatusError{StatusCode: resp.Status})
+			}
+			return nil, fmt.Errorf("%s", resp.Error)
+		default:
+			log.Warnf("Ignored plugin output of unknown type: %s", resp.Type)
+		}
+	}
+
+	err = p.stdout.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	_ = p.stop()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("plugin request aborted: %w", ctxErr)
+	}
+	return nil, fmt.Errorf("plugin exited before answering: %w", err)
+}
+
+// start launches the plugin process unless it is running
+func (p *Plugin) start() error {
+	if p.cmd != nil {
+		return nil
+	}
+
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Env = append(os.Environ(), p.env...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start plugin %s: %w", p.command[0], err)
+	}
+	log.Debugf("Started plugin %s (pid %d)", p.command[0], cmd.Process.Pid)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	p.cmd, p.stdin, p.stdout = cmd, stdin, scanner
+	return nil
+}
+
+// stop closes the stdin of the plugin, and waits for it to exit. It is killed if it does not exit in time.
+func (p *Plugin) stop() error {
+	if p.cmd == nil {
+		return nil
+	}
+	cmd, stdin := p.cmd, p.stdin
+	p.cmd, p.stdin, p.stdout = nil, nil, nil
+
+	_ = stdin.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(pluginStopTimeout):
+		_ = cmd.Process.Kill()
+		return <-done
+	}
+}
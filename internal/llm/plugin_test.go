@@ -0,0 +1,126 @@
+package llm_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+// TestPluginHelper is not a real test, but the plugin launched by the tests below from the test binary
+func TestPluginHelper(t *testing.T) {
+	if os.Getenv("ASKLLM_PLUGIN_HELPER") != "1" {
+		t.Skip("only run as a plugin process")
+	}
+
+	reply := func(resp testee.PluginResponse) {
+		data, _ := json.Marshal(resp)
+		fmt.Println(string(data))
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req testee.PluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Println("not a JSON line")
+			continue
+		}
+
+		switch req.Method {
+		case testee.PluginMethodListModels:
+			reply(testee.PluginResponse{ID: req.ID, Type: testee.PluginTypeResult, Models: []string{"wrapper-small", "wrapper-large"}})
+		case testee.PluginMethodChat:
+			prompt := req.Messages[len(req.Messages)-1].Content
+			switch prompt {
+			case "busy":
+				reply(testee.PluginResponse{ID: req.ID, Type: testee.PluginTypeError, Error: "overloaded", Status: 429})
+			case "crash":
+				os.Exit(3)
+			case "hang":
+				time.Sleep(time.Minute)
+			default:
+				content := fmt.Sprintf("%s says %s (key %s, temperature %v)", req.Model, prompt, os.Getenv("ASKLLM_API_KEY"), *req.Params.Temperature)
+				if req.Stream {
+					for _, chunk := range strings.SplitAfter(content, " ") {
+						reply(testee.PluginResponse{ID: req.ID, Type: testee.PluginTypeChunk, Content: chunk})
+					}
+				}
+				reply(testee.PluginResponse{ID: req.ID, Type: testee.PluginTypeResult, Content: content, Usage: &testee.Usage{PromptTokens: 1, CompletionTokens: 2}})
+			}
+		}
+	}
+	os.Exit(0)
+}
+
+func TestPlugin(t *testing.T) {
+	t.Setenv("ASKLLM_PLUGIN_HELPER", "1")
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"wrapper": {
+				Kind:    "plugin",
+				APIKey:  "secret",
+				Model:   "wrapper-small",
+				Command: []string{os.Args[0], "-test.run=^TestPluginHelper$"},
+			},
+		},
+	}
+
+	engine, err := testee.NewEngine("wrapper", "", cfg)
+	assert.NoError(t, err)
+	plugin := engine.(*testee.Plugin)
+	defer plugin.Close()
+
+	response, err := testee.Query(engine, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "wrapper-small says hello (key secret, temperature 0.2)", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 1, CompletionTokens: 2}, response.Usage)
+
+	var chunks []string
+	response, err = engine.ChatStream([]testee.Message{{Role: testee.RoleUser, Content: "hi"}}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, response.Content, strings.Join(chunks, ""))
+	assert.Len(t, chunks, 7)
+
+	models, err := engine.ListAllModels()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"wrapper-small", "wrapper-large"}, models)
+
+	_, err = testee.Query(engine, "busy")
+	var statusErr *utils.StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.True(t, testee.IsRetryable(err))
+
+	// the plugin is launched again after a crash
+	_, err = testee.Query(engine, "crash")
+	assert.ErrorContains(t, err, "plugin exited before answering")
+	response, err = testee.Query(engine, "again")
+	assert.NoError(t, err)
+	assert.Contains(t, response.Content, "says again")
+
+	// a hanging plugin is killed once the query is cancelled, then launched again
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = testee.Query(engine, "hang", testee.WithContext(ctx))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	response, err = testee.Query(engine, "once more")
+	assert.NoError(t, err)
+	assert.Contains(t, response.Content, "says once more")
+}
+
+func TestNewPlugin_NoCommand(t *testing.T) {
+	_, err := testee.NewPlugin("", config.LLMEngineConfig{})
+	assert.Error(t, err)
+}
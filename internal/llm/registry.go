@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/robinmin/askllm/internal/config"
+)
+
+// Capabilities are the optional features supported by a kind of engine
+type Capabilities struct {
+	JSONMode   bool // Honors WithJSONMode natively
+	ListModels bool // Lists the available models by querying the provider, rather than a built-in list
+	Tools      bool // Calls the tools given by WithTools
+}
+
+// Constructor creates an engine of the model with the engine config
+type Constructor func(model string, cfg config.LLMEngineConfig) (Engine, error)
+
+// EngineKind describes a kind of engine. Every named engine of the config is of a kind, which is the
+// name of the engine unless declared by its kind field.
+type EngineKind struct {
+	Name         string
+	DefaultModel string // Model used if none is given nor configured
+	Capabilities Capabilities
+	New          Constructor
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]EngineKind{}
+)
+
+// Register adds a kind of engine to the registry, replacing the one of the same name
+func Register(kind EngineKind) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(kind.Name)] = kind
+}
+
+// LookupKind returns the registered kind of engine by name
+func LookupKind(name string) (EngineKind, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	kind, ok := registry[strings.TrimSpace(strings.ToLower(name))]
+	return kind, ok
+}
+
+// Kinds lists the names of all registered kinds of engine in alphabetical order
+func Kinds() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KindName returns the name of the kind of the named engine, declared by the kind field of its config or by its name
+func KindName(engineName string, engineCfg config.LLMEngineConfig) string {
+	if engineCfg.Kind != "" {
+		return strings.ToLower(engineCfg.Kind)
+	}
+	return strings.ToLower(engineName)
+}
+
+// kindOf returns the kind of the named engine, declared by the kind field of its config or by its name
+func kindOf(engineName string, engineCfg config.LLMEngineConfig) (EngineKind, error) {
+	kindName := KindName(engineName, engineCfg)
+	kind, ok := LookupKind(kindName)
+	if !ok {
+		return EngineKind{}, fmt.Errorf("unsupported LLM engine: %s", kindName)
+	}
+	return kind, nil
+}
+
+// constructor adapts the constructor of a concrete engine, so that a failed one returns a nil Engine
+// rather than a typed nil pointer
+func constructor[T Engine](newEngine func(model string, cfg config.LLMEngineConfig) (T, error)) Constructor {
+	return func(model string, cfg config.LLMEngineConfig) (Engine, error) {
+		engine, err := newEngine(model, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+	}
+}
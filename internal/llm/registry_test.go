@@ -0,0 +1,70 @@
+package llm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("BuiltInKinds", func(t *testing.T) {
+		assert.Subset(t, testee.Kinds(), []string{"chatgpt", "claude", "gemini", "groq", "mock", "ollama"})
+
+		kind, ok := testee.LookupKind(" Groq ")
+		assert.True(t, ok)
+		assert.Equal(t, "gemma2-9b-it", kind.DefaultModel)
+		assert.True(t, kind.Capabilities.JSONMode)
+
+		assert.Equal(t, "echo", testee.GetDefaultModel("mock"))
+		assert.Equal(t, "", testee.GetDefaultModel("vllm"))
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		testee.Register(testee.EngineKind{
+			Name:         "scripted",
+			DefaultModel: "script-1",
+			New: func(model string, cfg config.LLMEngineConfig) (testee.Engine, error) {
+				return scripted(model, nil), nil
+			},
+		})
+
+		cfg := &config.Config{LLMEngines: map[string]config.LLMEngineConfig{"scripted": {}}}
+		engine, err := testee.NewEngine("scripted", "", cfg)
+		assert.NoError(t, err)
+		response, err := testee.Query(engine, "hi")
+		assert.NoError(t, err)
+		assert.Equal(t, "answer from script-1", response.Content)
+	})
+}
+
+func TestNewEngine_Kind(t *testing.T) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"vllm":     {Kind: "chatgpt", APIKey: "key1", BaseURL: "http://127.0.0.1:8000/v1", Model: "qwen2"},
+			"lmstudio": {Kind: "chatgpt", APIKey: "key2", BaseURL: "http://127.0.0.1:1234/v1"},
+			"offline":  {Kind: "mock"},
+			"broken":   {Kind: "unknown"},
+		},
+	}
+
+	engine, err := testee.NewEngine("vllm", "", cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &testee.ChatGPT{}, engine)
+
+	engine, err = testee.NewEngine("lmstudio", "", cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &testee.ChatGPT{}, engine)
+
+	engine, err = testee.NewEngine("offline", "", cfg)
+	assert.NoError(t, err)
+	models, err := engine.ListAllModels()
+	assert.NoError(t, err)
+	assert.Equal(t, testee.MockModels, models)
+
+	engine, err = testee.NewEngine("broken", "", cfg)
+	assert.EqualError(t, err, "unsupported LLM engine: unknown")
+	assert.Nil(t, engine)
+}
@@ -0,0 +1,100 @@
+package llm_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	testee "github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+// replay serves the HTTP traffic of the engines from the cassette in testdata/cassettes
+func replay(t *testing.T, name string) {
+	cassette, err := utils.LoadCassette(filepath.Join("testdata", "cassettes", name+".yaml"))
+	assert.NoError(t, err)
+	utils.SetTransport(cassette)
+	t.Cleanup(func() {
+		utils.SetTransport(nil)
+	})
+}
+
+func question(text string) []testee.Message {
+	return []testee.Message{{Role: testee.RoleUser, Content: text}}
+}
+
+func TestReplay_Groq(t *testing.T) {
+	replay(t, "groq")
+	engine, err := testee.NewGroq("gemma2-9b-it", config.LLMEngineConfig{APIKey: "key", BaseURL: "https://api.groq.com/openai/v1"})
+	assert.NoError(t, err)
+
+	response, err := testee.Chat(engine, question("What is the capital of France?"))
+	assert.NoError(t, err)
+	assert.Equal(t, "The capital of France is Paris.", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 15, CompletionTokens: 8}, response.Usage)
+
+	var chunks []string
+	response, err = engine.ChatStream(question("Count to three"), func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"One, ", "two, ", "three."}, chunks)
+	assert.Equal(t, "One, two, three.", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 12, CompletionTokens: 6}, response.Usage)
+
+	models, err := engine.ListAllModels()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gemma2-9b-it", "llama3-8b-8192"}, models)
+}
+
+func TestReplay_ChatGPT(t *testing.T) {
+	replay(t, "chatgpt")
+	engine, err := testee.NewChatGPT("gpt-4o-mini", config.LLMEngineConfig{APIKey: "key"})
+	assert.NoError(t, err)
+
+	response, err := testee.Query(engine, "What is the capital of France?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 20, CompletionTokens: 2}, response.Usage)
+
+	// the second interaction is rate limited
+	_, err = testee.Query(engine, "What is the capital of France?")
+	assert.Error(t, err)
+	assert.True(t, testee.IsRetryable(err))
+}
+
+func TestReplay_Claude(t *testing.T) {
+	replay(t, "claude")
+	engine, err := testee.NewClaude("claude-3-haiku-20240307", config.LLMEngineConfig{APIKey: "key"})
+	assert.NoError(t, err)
+
+	response, err := testee.Query(engine, "What is the capital of France?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 18, CompletionTokens: 4}, response.Usage)
+}
+
+func TestReplay_Ollama(t *testing.T) {
+	replay(t, "ollama")
+	engine, err := testee.NewOllama("gemma2", config.LLMEngineConfig{BaseURL: "http://127.0.0.1:11434"})
+	assert.NoError(t, err)
+
+	response, err := testee.Query(engine, "What is the capital of France?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 16, CompletionTokens: 3}, response.Usage)
+}
+
+func TestReplay_Gemini(t *testing.T) {
+	replay(t, "gemini")
+	engine, err := testee.NewGemini("gemini-1.5-pro", config.LLMEngineConfig{APIKey: "key"})
+	assert.NoError(t, err)
+
+	response, err := testee.Query(engine, "What is the capital of France?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", response.Content)
+	assert.Equal(t, testee.Usage{PromptTokens: 9, CompletionTokens: 2}, response.Usage)
+}
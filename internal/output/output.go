@@ -1,35 +1,113 @@
 package output
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/charmbracelet/glamour"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v2"
 
+	"github.com/robinmin/askllm/pkg/utils"
 	"github.com/robinmin/askllm/pkg/utils/log"
 )
 
-func HandleOutput(outputFile, content string) error {
-	if outputFile == "" || outputFile == "stdout" {
-		// show markdown in console
-		r, _ := glamour.NewTermRenderer(
-			// detect background color and pick either the default dark or light theme
-			glamour.WithAutoStyle(),
-			// wrap output at specific width (default is 80)
-			glamour.WithWordWrap(120),
-		)
-		out, err := r.Render(content)
+const (
+	FormatMarkdown = "markdown"
+	FormatRaw      = "raw"
+	FormatJSON     = "json"
+	FormatYAML     = "yaml"
+)
+
+// FormatContent converts the content into the specified format. Content for json and yaml
+// must be a JSON or YAML document.
+func FormatContent(content string, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", FormatMarkdown, FormatRaw:
+		return content, nil
+	case FormatJSON:
+		doc, err := decodeDocument(content)
 		if err != nil {
-			log.Error(err.Error())
-			return err
+			return "", err
 		}
-		fmt.Println(out)
-		return nil
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to format content as JSON: %v", err)
+		}
+		return string(data), nil
+	case FormatYAML:
+		doc, err := decodeDocument(content)
+		if err != nil {
+			return "", err
+		}
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to format content as YAML: %v", err)
+		}
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s, so far support 'markdown', 'raw', 'json', 'yaml'", format)
+	}
+}
+
+// decodeDocument decodes the content as JSON, or YAML as a fallback. A surrounding markdown code fence is ignored.
+func decodeDocument(content string) (any, error) {
+	content = StripCodeFence(content)
+
+	var doc any
+	if err := json.Unmarshal([]byte(content), &doc); err == nil {
+		return doc, nil
+	}
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, fmt.Errorf("content is not a valid JSON/YAML document: %v", err)
+	}
+	return utils.ToJSONCompatible(doc), nil
+}
+
+// StripCodeFence removes the markdown code fence around the content, if any
+func StripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") || !strings.HasSuffix(content, "```") || len(content) < 6 {
+		return content
+	}
+	content = strings.TrimSuffix(content[3:], "```")
+	// drop the language of the code fence
+	if idx := strings.Index(content, "\n"); idx >= 0 {
+		content = content[idx+1:]
+	}
+	return strings.TrimSpace(content)
+}
+
+// HandleOutput writes the content in the specified format into the output file, or shows it in console.
+// Only markdown content is rendered in console.
+func HandleOutput(outputFile, content string, format string) error {
+	formatted, err := FormatContent(content, format)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	if outputFile != "" && outputFile != "stdout" {
+		return os.WriteFile(outputFile, []byte(formatted), 0644)
+	}
+	if format != "" && strings.ToLower(format) != FormatMarkdown {
+		_, err := fmt.Fprintln(os.Stdout, formatted)
+		return err
 	}
-	return os.WriteFile(outputFile, []byte(content), 0644)
+	// show markdown in console
+	return OutputMarkdown(formatted)
 }
 
 func OutputMarkdown(content string) error {
+	return writeMarkdown(os.Stdout, content)
+}
+
+// writeMarkdown renders the markdown content into the writer
+func writeMarkdown(w io.Writer, content string) error {
 	// show markdown in console
 	r, _ := glamour.NewTermRenderer(
 		// detect background color and pick either the default dark or light theme
@@ -42,6 +120,84 @@ func OutputMarkdown(content string) error {
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Println(out)
+	_, err = fmt.Fprintln(w, out)
+	return err
+}
+
+// StreamWriter prints streamed tokens to the console as they arrive. On a terminal the raw
+// text is replaced with the rendered markdown once the stream completes, unless it has grown
+// taller than the screen.
+type StreamWriter struct {
+	out        io.Writer
+	isTerminal bool
+	width      int // terminal width in columns
+	height     int // terminal height in rows
+	column     int // current cursor column of the raw text
+	lines      int // number of lines the raw text occupies so far
+}
+
+func NewStreamWriter() *StreamWriter {
+	return NewStreamWriterFor(os.Stdout)
+}
+
+// NewStreamWriterFor creates a StreamWriter on the given writer. Markdown is only rendered if it is a terminal.
+func NewStreamWriterFor(out io.Writer) *StreamWriter {
+	sw := &StreamWriter{out: out}
+	if f, ok := out.(*os.File); ok {
+		fd := int(f.Fd())
+		if term.IsTerminal(fd) {
+			if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+				sw.isTerminal = true
+				sw.width = width
+				sw.height = height
+			}
+		}
+	}
+	return sw
+}
+
+// Write prints a chunk of raw text and keeps track of the occupied lines
+func (sw *StreamWriter) Write(chunk string) error {
+	if _, err := io.WriteString(sw.out, chunk); err != nil {
+		return err
+	}
+	if !sw.isTerminal {
+		return nil
+	}
+	for _, r := range chunk {
+		if r == '\n' {
+			sw.lines++
+			sw.column = 0
+			continue
+		}
+		// the terminal wraps before a rune not fitting in the line, so that a line filling the whole width and
+		// followed by a new line occupies a single line
+		width := runewidth.RuneWidth(r)
+		if sw.column+width > sw.width {
+			sw.lines++
+			sw.column = 0
+		}
+		sw.column += width
+	}
 	return nil
 }
+
+// Finish clears the raw text from the terminal and renders the full content as markdown.
+// When the output is not a terminal, or the raw text has scrolled out of the screen in part
+// and can not be cleared any more, the raw text is kept as is.
+func (sw *StreamWriter) Finish(content string) error {
+	if !sw.isTerminal || (sw.height > 0 && sw.lines > sw.height-1) {
+		_, err := fmt.Fprintln(sw.out)
+		return err
+	}
+
+	// move the cursor back to the beginning of the raw text and clear everything below
+	clear := "\r\033[J"
+	if sw.lines > 0 {
+		clear = fmt.Sprintf("\r\033[%dA\033[J", sw.lines)
+	}
+	if _, err := io.WriteString(sw.out, clear); err != nil {
+		return err
+	}
+	return writeMarkdown(sw.out, content)
+}
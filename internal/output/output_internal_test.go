@@ -0,0 +1,67 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamWriter_Lines(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		lines  int
+		column int
+	}{
+		{"SingleLine", []string{"hello"}, 0, 5},
+		{"NewLines", []string{"a\n", "b\nc"}, 2, 1},
+		{"Wrapped", []string{"0123456789ab"}, 1, 2},
+		{"FullWidth", []string{"0123456789"}, 0, 10},
+		{"FullWidthNewLine", []string{"01234", "56789", "\n", "x"}, 1, 1},
+		{"FullWidthWrapped", []string{"0123456789x"}, 1, 1},
+		{"WideRunes", []string{"中文中文中文"}, 1, 2},
+		{"EmptyLines", []string{"\n\n\n"}, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := &StreamWriter{out: &bytes.Buffer{}, isTerminal: true, width: 10, height: 24}
+			for _, chunk := range tt.chunks {
+				assert.NoError(t, sw.Write(chunk))
+			}
+			assert.Equal(t, tt.lines, sw.lines)
+			assert.Equal(t, tt.column, sw.column)
+		})
+	}
+}
+
+func TestStreamWriter_Finish(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		prefix   string
+		rendered bool
+	}{
+		{"SingleLine", "hello", "hello\r\033[J", true},
+		{"Lines", "a\nb\nc", "a\nb\nc\r\033[2A\033[J", true},
+		{"FillsScreen", "1\n2\n3\n4", "1\n2\n3\n4\r\033[3A\033[J", true},
+		{"TallerThanScreen", "1\n2\n3\n4\n5", "1\n2\n3\n4\n5\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sw := &StreamWriter{out: &out, isTerminal: true, width: 80, height: 4}
+			assert.NoError(t, sw.Write(tt.raw))
+			assert.NoError(t, sw.Finish("**done**"))
+			assert.True(t, bytes.HasPrefix(out.Bytes(), []byte(tt.prefix)), "%q", out.String())
+			if tt.rendered {
+				assert.Contains(t, out.String(), "done")
+				assert.NotContains(t, out.String(), "**done**")
+			} else {
+				assert.Equal(t, tt.prefix, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package output_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	testee "github.com/robinmin/askllm/internal/output"
+)
+
+func TestFormatContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		format   string
+		expected string
+		hasError bool
+	}{
+		{"Markdown", "# Title\n\n```json\n{}\n```", testee.FormatMarkdown, "# Title\n\n```json\n{}\n```", false},
+		{"Default", "plain text", "", "plain text", false},
+		{"Raw", "```\nkept\n```", testee.FormatRaw, "```\nkept\n```", false},
+		{"JSON", `{"b":1,"a":[true,null]}`, testee.FormatJSON, "{\n  \"a\": [\n    true,\n    null\n  ],\n  \"b\": 1\n}", false},
+		{"JSONFromFence", "```json\n{\"a\":1}\n```", "JSON", "{\n  \"a\": 1\n}", false},
+		{"JSONFromYAML", "a: 1\nb:\n  c: x", testee.FormatJSON, "{\n  \"a\": 1,\n  \"b\": {\n    \"c\": \"x\"\n  }\n}", false},
+		{"YAML", `{"a":1,"b":["x"]}`, testee.FormatYAML, "a: 1\nb:\n- x\n", false},
+		{"YAMLFromFence", "```yaml\na: 1\n```", testee.FormatYAML, "a: 1\n", false},
+		{"InvalidDocument", "not: [a document", testee.FormatJSON, "", true},
+		{"UnsupportedFormat", "{}", "xml", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted, err := testee.FormatContent(tt.content, tt.format)
+			if tt.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, formatted)
+		})
+	}
+}
+
+func TestStripCodeFence(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"NoFence", "  {\"a\":1}\n", "{\"a\":1}"},
+		{"Language", "```json\n{\"a\":1}\n```", "{\"a\":1}"},
+		{"NoLanguage", "```\na: 1\n```", "a: 1"},
+		{"Surrounded", "\n```yaml\n  a: 1\n```  \n", "a: 1"},
+		{"Unclosed", "```json\n{\"a\":1}", "```json\n{\"a\":1}"},
+		{"TooShort", "`````", "`````"},
+		{"Empty", "``````", ""},
+		{"InnerText", "see ```code``` here", "see ```code``` here"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, testee.StripCodeFence(tt.content))
+		})
+	}
+}
+
+func TestStreamWriter_NotTerminal(t *testing.T) {
+	var out bytes.Buffer
+	sw := testee.NewStreamWriterFor(&out)
+	assert.NoError(t, sw.Write("# Title\n"))
+	assert.NoError(t, sw.Write("text"))
+	assert.NoError(t, sw.Finish("# Title\ntext"))
+	// the raw text is kept as is
+	assert.Equal(t, "# Title\ntext\n", out.String())
+}
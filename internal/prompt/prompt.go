@@ -2,40 +2,68 @@ package prompt
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	// "fmt"
 	"os"
 	"text/template"
+	"unicode/utf8"
 
 	h2m "github.com/JohannesKaufmann/html-to-markdown"
 	"github.com/charmbracelet/glamour"
 
+	"github.com/robinmin/askllm/internal/config"
 	"github.com/robinmin/askllm/pkg/utils"
 	"github.com/robinmin/askllm/pkg/utils/log"
 )
 
+// DefaultMaxInputSize is the default size limit of the piped input
+const DefaultMaxInputSize = 1 << 20
+
 // PromptTemplate: This struct represents the overall configuration of the prompt template
 type PromptTemplate struct {
-	Id            string `yaml:"id"`                       // Unique identifier for the template
-	Name          string `yaml:"name"`                     // Name of the personality analyzer template
-	Description   string `yaml:"description"`              // Description of the template's functionality
-	Author        string `yaml:"author"`                   // Name of the template's author
-	DefaultEngine string `yaml:"default_engine,omitempty"` // Default LLM engine to use
-	DefaultModel  string `yaml:"default_model,omitempty"`  // Default LLM model to use
-	Variables     []struct {
-		Name       string `yaml:"name"`       // Name of the variable
-		Vtype      string `yaml:"vtype"`      // Variable type
-		Otype      string `yaml:"otype"`      // Output type of the variable
-		Default    string `yaml:"default"`    // Default value for the variable
-		Validation string `yaml:"validation"` // Regular expression for validation
-	} `yaml:"variables"` // List of variables used by the template
-	Template string `yaml:"template"` //  The template string to be used for analysis
+	Id             string                  `yaml:"id"`                        // Unique identifier for the template
+	Name           string                  `yaml:"name"`                      // Name of the personality analyzer template
+	Description    string                  `yaml:"description"`               // Description of the template's functionality
+	Author         string                  `yaml:"author"`                    // Name of the template's author
+	DefaultEngine  string                  `yaml:"default_engine,omitempty"`  // Default LLM engine to use
+	DefaultModel   string                  `yaml:"default_model,omitempty"`   // Default LLM model to use
+	Variables      []PromptVariable        `yaml:"variables"`                 // List of variables used by the template
+	System         string                  `yaml:"system,omitempty"`          // The template string of the system prompt, if any
+	Template       string                  `yaml:"template"`                  //  The template string to be used for analysis
+	ResponseSchema any                     `yaml:"response_schema,omitempty"` // JSON Schema of the expected response, as YAML mapping or JSON string
+	SchemaRetries  int                     `yaml:"schema_retries,omitempty"`  // Times to re-ask the model with the validation errors
+	Parameters     config.GenerationParams `yaml:"parameters,omitempty"`      // Generation parameters overriding the engine config
+
+	systemPrompt string // System prompt rendered by GetPrompt
+}
+
+// PromptVariable: This struct represents a variable used by the prompt template
+type PromptVariable struct {
+	Name       string   `yaml:"name"`               // Name of the variable
+	Vtype      string   `yaml:"vtype"`              // Variable type: string, int, bool, enum/select, file, url or stdin
+	Otype      string   `yaml:"otype"`              // Output type of the variable
+	Default    string   `yaml:"default"`            // Default value for the variable
+	Required   bool     `yaml:"required,omitempty"` // The variable must have a non-empty value
+	Validation string   `yaml:"validation"`         // Regular expression for validation
+	Options    []string `yaml:"options,omitempty"`  // Allowed values for vtype enum/select
+}
+
+// ValidationError lists every variable failed the validation
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid prompt variables:\n  - " + strings.Join(e.Errors, "\n  - ")
 }
 
 func NewPromptTemplate(promptFile string) (*PromptTemplate, error) {
@@ -56,8 +84,69 @@ func (pt *PromptTemplate) getDefaultVars() (map[string]any, error) {
 	return defaults, nil
 }
 
-// render the template
-func (pt *PromptTemplate) GetPrompt(vars map[string]any) (string, error) {
+// Validate checks the merged values against the type, options and regex of each variable,
+// and converts the typed values (int, bool). All failures are returned together.
+func (pt *PromptTemplate) Validate(values map[string]any) error {
+	var failures []string
+	for _, v := range pt.Variables {
+		raw, ok := values[v.Name]
+		value := ""
+		if ok && raw != nil {
+			value = fmt.Sprint(raw)
+		}
+
+		if value == "" {
+			if v.Required {
+				failures = append(failures, fmt.Sprintf("%s: is required", v.Name))
+			}
+			// nothing to validate for an empty optional value
+			continue
+		}
+
+		if v.Validation != "" {
+			re, err := regexp.Compile(v.Validation)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: invalid validation regex %q: %v", v.Name, v.Validation, err))
+				continue
+			}
+			if !re.MatchString(value) {
+				failures = append(failures, fmt.Sprintf("%s: value %q does not match %q", v.Name, value, v.Validation))
+				continue
+			}
+		}
+
+		switch strings.ToLower(v.Vtype) {
+		case "int", "integer":
+			num, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: value %q is not an integer", v.Name, value))
+				continue
+			}
+			values[v.Name] = num
+		case "bool", "boolean":
+			flag, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: value %q is not a boolean", v.Name, value))
+				continue
+			}
+			values[v.Name] = flag
+		case "enum", "select":
+			if len(v.Options) == 0 {
+				failures = append(failures, fmt.Sprintf("%s: no options defined for vtype %s", v.Name, v.Vtype))
+			} else if !slices.Contains(v.Options, value) {
+				failures = append(failures, fmt.Sprintf("%s: value %q is not one of [%s]", v.Name, value, strings.Join(v.Options, ", ")))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ValidationError{Errors: failures}
+	}
+	return nil
+}
+
+// render the template, fetching the variables of vtype url within the context
+func (pt *PromptTemplate) GetPrompt(ctx context.Context, vars map[string]any) (string, error) {
 	// get default values
 	defaults, err := pt.getDefaultVars()
 	if err != nil {
@@ -70,6 +159,11 @@ func (pt *PromptTemplate) GetPrompt(vars map[string]any) (string, error) {
 		defaults[key] = val
 	}
 
+	// validate all values before loading any external content
+	if err := pt.Validate(defaults); err != nil {
+		return "", err
+	}
+
 	// replace value for all vtype=file/url with content if any
 	for _, v := range pt.Variables {
 		if strings.ToLower(v.Vtype) == "file" {
@@ -88,7 +182,12 @@ func (pt *PromptTemplate) GetPrompt(vars map[string]any) (string, error) {
 			if ok && len(value) > 0 {
 				// Load web content from the URL
 				log.Infof("Fetch web page from [%v]......", value)
-				resp, err := http.Get(value)
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, value, nil)
+				if err != nil {
+					log.Errorf("Failed to fetch URL %s: %v", value, err)
+					continue
+				}
+				resp, err := http.DefaultClient.Do(req)
 				if err != nil {
 					log.Errorf("Failed to fetch URL %s: %v", value, err)
 					continue
@@ -140,14 +239,27 @@ func (pt *PromptTemplate) GetPrompt(vars map[string]any) (string, error) {
 		}
 	}
 
-	// render the prompt template
-	tmpl, err := template.New("").Parse(pt.Template)
+	// render the system prompt and the prompt template
+	pt.systemPrompt, err = renderTemplate(pt.System, defaults)
+	if err != nil {
+		return "", fmt.Errorf("failed to render system prompt: %v", err)
+	}
+	return renderTemplate(pt.Template, defaults)
+}
+
+// SystemPrompt returns the system prompt rendered by GetPrompt
+func (pt *PromptTemplate) SystemPrompt() string {
+	return pt.systemPrompt
+}
+
+func renderTemplate(text string, vars map[string]any) (string, error) {
+	tmpl, err := template.New("").Parse(text)
 	if err != nil {
 		return "", err
 	}
 
 	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, defaults)
+	err = tmpl.Execute(&buffer, vars)
 	if err != nil {
 		return "", err
 	}
@@ -155,7 +267,10 @@ func (pt *PromptTemplate) GetPrompt(vars map[string]any) (string, error) {
 	return buffer.String(), nil
 }
 
-func (pt *PromptTemplate) GetParameters(engine string, model string, defaultEngine string, defaultModel string) (string, string) {
+// GetParameters returns the engine and model to use, the given ones taking precedence over the ones of the prompt
+// template, then the default ones. The default model applies to the default engine only. A model alias is resolved
+// to its engine and model.
+func (pt *PromptTemplate) GetParameters(engine string, model string, defaultEngine string, defaultModel string, aliases config.Aliases) (string, string) {
 	var tmpEngine string
 	var tmpModel string
 
@@ -176,12 +291,12 @@ func (pt *PromptTemplate) GetParameters(engine string, model string, defaultEngi
 	} else {
 		if len(pt.DefaultModel) > 0 {
 			tmpModel = pt.DefaultModel
-		} else {
+		} else if strings.EqualFold(tmpEngine, defaultEngine) {
 			tmpModel = defaultModel
 		}
 	}
 
-	return tmpEngine, tmpModel
+	return aliases.Resolve(tmpEngine, tmpModel)
 }
 
 func getPlaintTextPrompt(promptFile string, input string) (string, error) {
@@ -239,7 +354,54 @@ func parseQueryString(queryString string) (map[string]any, error) {
 	return queryParams, nil
 }
 
-func GeneratePrompt(promptFile string, payload string) (*PromptTemplate, string, error) {
+// ReadInput reads the piped input up to limit bytes, and rejects binary content
+func ReadInput(r io.Reader, limit int64) (string, error) {
+	if limit <= 0 {
+		limit = DefaultMaxInputSize
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %v", err)
+	}
+	if int64(len(data)) > limit {
+		return "", fmt.Errorf("stdin exceeds the limit of %d bytes", limit)
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", fmt.Errorf("stdin looks like binary data, only text input is supported")
+	}
+	return string(data), nil
+}
+
+// stdinVariable returns the name of the variable receiving the piped input, if any
+func (pt *PromptTemplate) stdinVariable() string {
+	for _, v := range pt.Variables {
+		if strings.ToLower(v.Vtype) == "stdin" {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// appendInput appends the piped input to the prompt text
+func appendInput(text string, input string) string {
+	if len(input) == 0 {
+		return text
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		return input
+	}
+	return text + "\n\n" + input
+}
+
+func GeneratePrompt(ctx context.Context, promptFile string, payload string) (*PromptTemplate, string, error) {
+	return GeneratePromptWithInput(ctx, promptFile, payload, "")
+}
+
+// GeneratePromptWithInput generates the prompt with the piped input. For a prompt template the input is
+// assigned to the variable of vtype stdin, otherwise it is appended to the prompt text. The variables of vtype
+// url are fetched within the context.
+func GeneratePromptWithInput(ctx context.Context, promptFile string, payload string, input string) (*PromptTemplate, string, error) {
 	var pt *PromptTemplate
 	var promptText string
 	var err error
@@ -262,24 +424,38 @@ func GeneratePrompt(promptFile string, payload string) (*PromptTemplate, string,
 					return pt, "", err
 				}
 			}
+			if len(input) > 0 {
+				if name := pt.stdinVariable(); name != "" {
+					if vars == nil {
+						vars = make(map[string]any)
+					}
+					if _, ok := vars[name]; !ok {
+						vars[name] = input
+					}
+				} else {
+					log.Warn("Ignored the piped input, as no variable of vtype stdin defined in " + promptFile)
+				}
+			}
 
-			promptText, err = pt.GetPrompt(vars)
+			promptText, err = pt.GetPrompt(ctx, vars)
 			if err != nil {
 				log.Error("Error getting prompt: " + err.Error())
 				return pt, "", err
 			}
 		} else {
 			// load prompt from external file (compatible with old version)
+			pt = &PromptTemplate{}
 			promptText, err = getPlaintTextPrompt(promptFile, payload)
 			if err != nil {
 				log.Error("Error getting prompt: " + err.Error())
 				return pt, "", err
 			}
+			promptText = appendInput(promptText, input)
 		}
 	} else {
 		// load prompt from command line directly
 		pt = &PromptTemplate{}
-		promptText = payload
+		promptText = appendInput(payload, input)
 	}
 
 	return pt, promptText, err
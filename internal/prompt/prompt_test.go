@@ -2,10 +2,13 @@ package prompt_test
 
 import (
 	// "fmt"
+	"bytes"
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/robinmin/askllm/internal/config"
 	testee "github.com/robinmin/askllm/internal/prompt"
 	"github.com/robinmin/askllm/pkg/utils"
 )
@@ -51,7 +54,7 @@ func TestGeneratePrompt(t *testing.T) {
 		}()
 
 		// Assuming NewPromptTemplate and GetPrompt are mocked to return valid results
-		pt, text, err := testee.GeneratePrompt(tmpFile, "content=abc&url_content=123")
+		pt, text, err := testee.GeneratePrompt(context.Background(), tmpFile, "content=abc&url_content=123")
 		assert.NotNil(t, pt)
 		assert.NoError(t, err)
 		assert.NotEmpty(t, text)
@@ -59,7 +62,7 @@ func TestGeneratePrompt(t *testing.T) {
 
 	t.Run("InvalidYAMLFile", func(t *testing.T) {
 		// Assuming NewPromptTemplate returns an error
-		pt, text, err := testee.GeneratePrompt("invalid_prompt.yaml", "key1=value1&key2=value2")
+		pt, text, err := testee.GeneratePrompt(context.Background(), "invalid_prompt.yaml", "key1=value1&key2=value2")
 		assert.Nil(t, pt)
 		assert.Error(t, err)
 		assert.Empty(t, text)
@@ -67,13 +70,178 @@ func TestGeneratePrompt(t *testing.T) {
 
 	t.Run("ValidPlainText", func(t *testing.T) {
 		content := "This is a plain text prompt"
-		pt, text, err := testee.GeneratePrompt("", content)
+		pt, text, err := testee.GeneratePrompt(context.Background(), "", content)
 		assert.NotNil(t, pt)
 		assert.NoError(t, err)
 		assert.Equal(t, content, text)
 	})
 }
 
+func TestPromptTemplate_Validate(t *testing.T) {
+	pt := &testee.PromptTemplate{
+		Variables: []testee.PromptVariable{
+			{Name: "name", Vtype: "string", Required: true},
+			{Name: "lang", Vtype: "string", Default: "en", Validation: "^[a-z]{2}$"},
+			{Name: "count", Vtype: "int", Default: "3"},
+			{Name: "verbose", Vtype: "bool", Default: "false"},
+			{Name: "style", Vtype: "enum", Default: "short", Options: []string{"short", "long"}},
+			{Name: "note", Vtype: "string"},
+		},
+		Template: "{{ .name }}|{{ .lang }}|{{ .count }}|{{ if .verbose }}verbose{{ else }}quiet{{ end }}|{{ .style }}",
+	}
+
+	t.Run("ValidValues", func(t *testing.T) {
+		text, err := pt.GetPrompt(context.Background(), map[string]any{"name": "Robin", "verbose": "true", "count": "5"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Robin|en|5|verbose|short", text)
+	})
+
+	t.Run("DefaultValues", func(t *testing.T) {
+		text, err := pt.GetPrompt(context.Background(), map[string]any{"name": "Robin"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Robin|en|3|quiet|short", text)
+	})
+
+	t.Run("AggregatedErrors", func(t *testing.T) {
+		text, err := pt.GetPrompt(context.Background(), map[string]any{"lang": "english", "count": "many", "verbose": "maybe", "style": "medium"})
+		assert.Empty(t, text)
+
+		var validationErr *testee.ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+		assert.Len(t, validationErr.Errors, 5)
+		for _, name := range []string{"name:", "lang:", "count:", "verbose:", "style:"} {
+			assert.Contains(t, err.Error(), name)
+		}
+	})
+
+	t.Run("OptionalWithoutDefault", func(t *testing.T) {
+		optional := &testee.PromptTemplate{
+			Variables: []testee.PromptVariable{{Name: "topic", Vtype: "string"}},
+			Template:  "Tell me about [{{ .topic }}]",
+		}
+		text, err := optional.GetPrompt(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "Tell me about []", text)
+	})
+
+	t.Run("InvalidRegex", func(t *testing.T) {
+		invalid := &testee.PromptTemplate{
+			Variables: []testee.PromptVariable{{Name: "name", Validation: "[a-z"}},
+		}
+		assert.Error(t, invalid.Validate(map[string]any{"name": "abc"}))
+	})
+}
+
+func TestPromptTemplate_SystemPrompt(t *testing.T) {
+	pt := &testee.PromptTemplate{
+		Variables: []testee.PromptVariable{{Name: "lang", Vtype: "string", Default: "Go"}},
+		System:    "You are a {{ .lang }} expert.",
+		Template:  "Review the code.",
+	}
+
+	text, err := pt.GetPrompt(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Review the code.", text)
+	assert.Equal(t, "You are a Go expert.", pt.SystemPrompt())
+
+	// no system prompt by default
+	assert.Empty(t, (&testee.PromptTemplate{}).SystemPrompt())
+}
+
+func TestPromptTemplate_GetParameters(t *testing.T) {
+	aliases := config.Aliases{"smart": {Engine: "claude", Model: "claude-3-opus-20240229"}}
+
+	tests := []struct {
+		name          string
+		pt            testee.PromptTemplate
+		engine, model string
+		expected      [2]string
+	}{
+		{"Defaults", testee.PromptTemplate{}, "", "", [2]string{"ollama", "gemma2"}},
+		{"GivenEngine", testee.PromptTemplate{}, "groq", "", [2]string{"groq", ""}},
+		{"GivenModel", testee.PromptTemplate{}, "groq", "llama3-8b", [2]string{"groq", "llama3-8b"}},
+		{"Template", testee.PromptTemplate{DefaultEngine: "groq", DefaultModel: "llama3-8b"}, "", "", [2]string{"groq", "llama3-8b"}},
+		{"TemplateEngine", testee.PromptTemplate{DefaultEngine: "OLLAMA"}, "", "", [2]string{"OLLAMA", "gemma2"}},
+		{"Alias", testee.PromptTemplate{}, "groq", "smart", [2]string{"claude", "claude-3-opus-20240229"}},
+		{"TemplateAlias", testee.PromptTemplate{DefaultModel: "Smart"}, "", "", [2]string{"claude", "claude-3-opus-20240229"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, model := tt.pt.GetParameters(tt.engine, tt.model, "ollama", "gemma2", aliases)
+			assert.Equal(t, tt.expected, [2]string{engine, model})
+		})
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		limit    int64
+		expected string
+		hasError bool
+	}{
+		{"Text", []byte("diff --git a/main.go b/main.go"), 100, "diff --git a/main.go b/main.go", false},
+		{"Empty", []byte(""), 100, "", false},
+		{"TooLarge", []byte("0123456789"), 5, "", true},
+		{"BinaryNul", []byte{'a', 0, 'b'}, 100, "", true},
+		{"InvalidUTF8", []byte{0xff, 0xfe, 0xfd}, 100, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := testee.ReadInput(bytes.NewReader(tt.input), tt.limit)
+			if tt.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, text)
+		})
+	}
+}
+
+func TestGeneratePromptWithInput(t *testing.T) {
+	t.Run("DirectPrompt", func(t *testing.T) {
+		_, text, err := testee.GeneratePromptWithInput(context.Background(), "", "Review this diff:", "+ added line")
+		assert.NoError(t, err)
+		assert.Equal(t, "Review this diff:\n\n+ added line", text)
+	})
+
+	t.Run("InputOnly", func(t *testing.T) {
+		_, text, err := testee.GeneratePromptWithInput(context.Background(), "", "", "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", text)
+	})
+
+	t.Run("StdinVariable", func(t *testing.T) {
+		data := []byte(`
+id: review
+variables:
+  - name: "diff"
+    vtype: "stdin"
+    required: true
+  - name: "lang"
+    vtype: "string"
+    default: "Go"
+template: "Review the {{ .lang }} diff: {{ .diff }}"
+`)
+		tmpFile, err := utils.WriteTempFile("prompt_stdin", "yaml", data)
+		assert.NoError(t, err)
+		defer func() {
+			assert.NoError(t, utils.CleanupTempFile(tmpFile))
+		}()
+
+		_, text, err := testee.GeneratePromptWithInput(context.Background(), tmpFile, "", "+ added line")
+		assert.NoError(t, err)
+		assert.Equal(t, "Review the Go diff: + added line", text)
+
+		// the stdin variable is required
+		_, _, err = testee.GeneratePromptWithInput(context.Background(), tmpFile, "", "")
+		assert.Error(t, err)
+	})
+}
+
 func generateSamplePrompt() string {
 	return `
 id: prompt_web_content_extractor
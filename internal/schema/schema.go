@@ -0,0 +1,129 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/output"
+	"github.com/robinmin/askllm/pkg/utils"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// DefaultRetries is the default number of times to re-ask the model with the validation errors
+const DefaultRetries = 2
+
+const schemaURL = "response_schema.json"
+
+// Validator validates the responses against the JSON schema declared in a prompt template
+type Validator struct {
+	schema *jsonschema.Schema
+	source string // JSON text of the schema
+}
+
+// Compile compiles the schema definition, either a JSON string or a mapping decoded from YAML
+func Compile(definition any) (*Validator, error) {
+	var source []byte
+	if text, ok := definition.(string); ok {
+		source = []byte(text)
+	} else {
+		data, err := json.MarshalIndent(utils.ToJSONCompatible(definition), "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response schema: %v", err)
+		}
+		source = data
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(schemaURL, bytes.NewReader(source)); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %v", err)
+	}
+	compiled, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response schema: %v", err)
+	}
+	return &Validator{schema: compiled, source: string(source)}, nil
+}
+
+// Instruction tells the model to reply with a JSON document conforming to the schema
+func (v *Validator) Instruction() string {
+	return "Respond only with a JSON document (no explanation) that conforms to the following JSON Schema:\n\n```json\n" + v.source + "\n```"
+}
+
+// Validate extracts the JSON document from the response and validates it against the schema
+func (v *Validator) Validate(response string) (string, error) {
+	content := output.StripCodeFence(response)
+
+	var doc any
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return "", fmt.Errorf("response is not a valid JSON document: %v", err)
+	}
+	if err := v.schema.Validate(doc); err != nil {
+		var verr *jsonschema.ValidationError
+		if errors.As(err, &verr) {
+			return "", fmt.Errorf("response does not conform to the schema:\n%s", strings.Join(leafErrors(verr), "\n"))
+		}
+		return "", err
+	}
+	return content, nil
+}
+
+// leafErrors lists the most specific validation errors
+func leafErrors(verr *jsonschema.ValidationError) []string {
+	if len(verr.Causes) == 0 {
+		location := verr.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{fmt.Sprintf("- at %s: %s", location, verr.Message)}
+	}
+
+	var result []string
+	for _, cause := range verr.Causes {
+		result = append(result, leafErrors(cause)...)
+	}
+	return result
+}
+
+// Query asks the engine for a response conforming to the schema. An invalid response is sent back to
+// the model together with the validation errors, up to retries times before failing. The usage of all
+// attempts is summed up.
+func Query(engine llm.Engine, messages []llm.Message, v *Validator, retries int, options ...llm.CallOption) (*llm.Response, error) {
+	conversation := make([]llm.Message, 0, len(messages)+retries*2)
+	conversation = append(conversation, messages...)
+	if last := len(conversation) - 1; last >= 0 && conversation[last].Role == llm.RoleUser {
+		conversation[last].Content += "\n\n" + v.Instruction()
+	} else {
+		conversation = append(conversation, llm.Message{Role: llm.RoleUser, Content: v.Instruction()})
+	}
+
+	var lastErr error
+	var usage llm.Usage
+	for attempt := 0; attempt <= retries; attempt++ {
+		response, err := llm.Chat(engine, conversation, append(options[:len(options):len(options)], llm.WithJSONMode())...)
+		if err != nil {
+			return nil, err
+		}
+		usage = usage.Add(response.Usage)
+
+		result, err := v.Validate(response.Content)
+		if err == nil {
+			return &llm.Response{Engine: response.Engine, Model: response.Model, Content: result, Usage: usage}, nil
+		}
+		lastErr = err
+		log.Warnf("Invalid structured response (attempt %d of %d): %v", attempt+1, retries+1, err)
+
+		conversation = append(conversation,
+			llm.Message{Role: llm.RoleAssistant, Content: response.Content},
+			llm.Message{Role: llm.RoleUser, Content: err.Error() + "\n\nPlease fix the errors and reply with the corrected JSON document only."},
+		)
+	}
+	return nil, fmt.Errorf("no valid response after %d attempts: %v", retries+1, lastErr)
+}
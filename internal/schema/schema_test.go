@@ -0,0 +1,104 @@
+package schema_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+	testee "github.com/robinmin/askllm/internal/schema"
+)
+
+const personSchema = `{
+  "type": "object",
+  "properties": {
+    "name": {"type": "string"},
+    "age": {"type": "integer", "minimum": 0}
+  },
+  "required": ["name", "age"]
+}`
+
+// answering replies with the responses in order
+func answering(responses ...string) *llmtest.Engine {
+	return llmtest.New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+		response := responses[0]
+		responses = responses[1:]
+		return &llm.Response{Engine: "fake", Model: "fake-small", Content: response, Usage: llm.EstimateUsage(messages, response)}, nil
+	})
+}
+
+func TestCompile(t *testing.T) {
+	t.Run("JSONString", func(t *testing.T) {
+		v, err := testee.Compile(personSchema)
+		assert.NoError(t, err)
+		assert.Contains(t, v.Instruction(), `"required": ["name", "age"]`)
+	})
+
+	t.Run("YAMLMapping", func(t *testing.T) {
+		definition := map[any]any{
+			"type":     "object",
+			"required": []any{"name"},
+		}
+		v, err := testee.Compile(definition)
+		assert.NoError(t, err)
+		_, err = v.Validate(`{"name": "Bob"}`)
+		assert.NoError(t, err)
+	})
+
+	t.Run("InvalidSchema", func(t *testing.T) {
+		_, err := testee.Compile(`{"type": 42}`)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidator_Validate(t *testing.T) {
+	v, err := testee.Compile(personSchema)
+	assert.NoError(t, err)
+
+	result, err := v.Validate("```json\n{\"name\": \"Alice\", \"age\": 30}\n```")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "Alice", "age": 30}`, result)
+
+	_, err = v.Validate("not a json")
+	assert.ErrorContains(t, err, "not a valid JSON document")
+
+	_, err = v.Validate(`{"name": "Alice", "age": -1}`)
+	assert.ErrorContains(t, err, "at /age")
+}
+
+func TestQuery(t *testing.T) {
+	v, err := testee.Compile(personSchema)
+	assert.NoError(t, err)
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Who is Alice?"}}
+
+	t.Run("RetriesWithErrors", func(t *testing.T) {
+		engine := answering(`{"name": "Alice"}`, `{"name": "Alice", "age": 30}`)
+		result, err := testee.Query(engine, messages, v, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name": "Alice", "age": 30}`, result.Content)
+		assert.Equal(t, "fake", result.Engine)
+		assert.Equal(t, "fake-small", result.Model)
+		assert.True(t, engine.LastCall().Options.JSONMode)
+
+		// the instruction goes with the prompt, the errors are sent back on retry
+		calls := engine.Calls()
+		assert.Len(t, calls, 2)
+		assert.Len(t, calls[0].Messages, 1)
+		assert.Contains(t, calls[0].Messages[0].Content, "JSON Schema")
+		assert.Len(t, calls[1].Messages, 3)
+		assert.Contains(t, calls[1].Messages[2].Content, "missing properties")
+
+		// the usage of both attempts is counted
+		assert.Greater(t, result.Usage.PromptTokens, llm.EstimateUsage(calls[0].Messages, "").PromptTokens)
+
+		// the caller's messages are left untouched
+		assert.Equal(t, "Who is Alice?", messages[0].Content)
+	})
+
+	t.Run("GivesUp", func(t *testing.T) {
+		engine := answering("nope", "still nope")
+		_, err := testee.Query(engine, messages, v, 1)
+		assert.ErrorContains(t, err, "no valid response after 2 attempts")
+	})
+}
@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+const DefaultAddr = "127.0.0.1:8080"
+
+// EngineFactory creates the LLM engine used to serve a single request
+type EngineFactory func(engineType, model string, cfg *config.Config) (llm.Engine, error)
+
+// ModelLister lists the models of all (or the specified) engines, along with the failures of some engines
+type ModelLister func(engineType string, cfg *config.Config) (map[string][]string, error)
+
+// Server exposes the configured LLM engines through an OpenAI-compatible HTTP API
+type Server struct {
+	cfg           *config.Config
+	defaultEngine string
+	defaultModel  string
+	newEngine     EngineFactory
+	listModels    ModelLister
+}
+
+func NewServer(cfg *config.Config, defaultEngine string, defaultModel string) *Server {
+	if defaultEngine == "" {
+		defaultEngine = cfg.Sys.DefaultEngine
+	}
+	return &Server{
+		cfg:           cfg,
+		defaultEngine: strings.ToLower(defaultEngine),
+		defaultModel:  defaultModel,
+		newEngine:     llm.NewEngine,
+		listModels:    llm.GetAllModels,
+	}
+}
+
+// WithEngineFactory replaces the engine factory, mainly for testing purposes
+func (s *Server) WithEngineFactory(factory EngineFactory) *Server {
+	s.newEngine = factory
+	return s
+}
+
+// WithModelLister replaces the model lister, mainly for testing purposes
+func (s *Server) WithModelLister(lister ModelLister) *Server {
+	s.listModels = lister
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("GET /v1/models", s.handleModels)
+	return s.authenticate(mux)
+}
+
+// ListenAndServe serves the gateway until the context is cancelled
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Infof("Serving OpenAI-compatible API on http://%s/v1", addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		log.Info("Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// ChatCompletionRequest is the subset of the OpenAI chat completion request supported by the gateway
+type ChatCompletionRequest struct {
+	Model       string                  `json:"model"`
+	Messages    []ChatCompletionMessage `json:"messages"`
+	Stream      bool                    `json:"stream,omitempty"`
+	Temperature *float64                `json:"temperature,omitempty"`
+	TopP        *float64                `json:"top_p,omitempty"`
+	MaxTokens   *int                    `json:"max_tokens,omitempty"`
+	Stop        StopSequences           `json:"stop,omitempty"`
+	Seed        *int                    `json:"seed,omitempty"`
+}
+
+// Params returns the generation parameters of the request, overriding the ones of the engine config
+func (req ChatCompletionRequest) Params() config.GenerationParams {
+	return config.GenerationParams{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		Stop:        req.Stop,
+		Seed:        req.Seed,
+	}
+}
+
+// ChatMessages converts the messages of the request into the conversation of the engine
+func (req ChatCompletionRequest) ChatMessages() []llm.Message {
+	messages := make([]llm.Message, 0, len(req.Messages))
+	for _, message := range req.Messages {
+		messages = append(messages, llm.Message{
+			Role:       message.Role,
+			Content:    string(message.Content),
+			ToolCalls:  message.ToolCalls,
+			ToolCallID: message.ToolCallID,
+		})
+	}
+	return messages
+}
+
+// ChatCompletionMessage is a message of the chat completion request
+type ChatCompletionMessage struct {
+	Role       string         `json:"role"`
+	Content    MessageContent `json:"content"`
+	ToolCalls  []llm.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+// MessageContent is the text of a message, given either as a string or as an array of content parts. Only the text
+// parts are supported, joined by new lines.
+type MessageContent string
+
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err == nil {
+		if text != nil {
+			*c = MessageContent(*text)
+		}
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return fmt.Errorf("unsupported content part type: %s", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	*c = MessageContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+// StopSequences are the sequences to stop the generation at, given either as a string or as an array of strings
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var sequence string
+	if err := json.Unmarshal(data, &sequence); err == nil {
+		*s = StopSequences{sequence}
+		return nil
+	}
+	var sequences []string
+	if err := json.Unmarshal(data, &sequences); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = sequences
+	return nil
+}
+
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      llm.Message `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   ChatCompletionUsage    `json:"usage"`
+}
+
+type ChatCompletionDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type ChatCompletionChunkChoice struct {
+	Index        int                 `json:"index"`
+	Delta        ChatCompletionDelta `json:"delta"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+}
+
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type ModelListResponse struct {
+	Object string        `json:"object"`
+	Data   []ModelObject `json:"data"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "messages must not be empty")
+		return
+	}
+
+	engineName, modelName := s.resolveModel(req.Model)
+	engine, err := s.newEngine(engineName, modelName, s.cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	defer llm.CloseEngine(engine)
+
+	// the query is aborted once the client disconnects, or beyond the timeout of a request
+	ctx := r.Context()
+	if s.cfg.Sys.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Sys.Timeout)
+		defer cancel()
+	}
+
+	log.Infof("[SERVER] chat completion via %s/%s (%d messages)", engineName, modelName, len(req.Messages))
+	options := []llm.CallOption{llm.WithContext(ctx), llm.WithParams(req.Params(), config.GenerationParams{})}
+	if req.Stream {
+		s.streamChatCompletions(w, req, engine, options...)
+		return
+	}
+
+	response, err := llm.Chat(engine, req.ChatMessages(), options...)
+	if err != nil {
+		log.Errorf("[SERVER] chat completion failed: %v", err)
+		writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	writeJSON(w, http.StatusOK, ChatCompletionResponse{
+		ID:      fmt.Sprintf("chatcmpl-%d", now.UnixNano()),
+		Object:  "chat.completion",
+		Created: now.Unix(),
+		Model:   req.Model,
+		Choices: []ChatCompletionChoice{
+			{
+				Index:        0,
+				Message:      llm.Message{Role: llm.RoleAssistant, Content: response.Content},
+				FinishReason: "stop",
+			},
+		},
+		Usage: ChatCompletionUsage{
+			PromptTokens:     response.Usage.PromptTokens,
+			CompletionTokens: response.Usage.CompletionTokens,
+			TotalTokens:      response.Usage.TotalTokens(),
+		},
+	})
+}
+
+// streamChatCompletions sends the response as server-sent events of chat.completion.chunk objects
+func (s *Server) streamChatCompletions(w http.ResponseWriter, req ChatCompletionRequest, engine llm.Engine, options ...llm.CallOption) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "server_error", "streaming is not supported by the connection")
+		return
+	}
+
+	now := time.Now()
+	id := fmt.Sprintf("chatcmpl-%d", now.UnixNano())
+	started := false
+	writeChunk := func(delta ChatCompletionDelta, finishReason *string) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		data, err := json.Marshal(ChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: now.Unix(),
+			Model:   req.Model,
+			Choices: []ChatCompletionChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	_, err := engine.ChatStream(req.ChatMessages(), func(chunk string) error {
+		if !started {
+			return writeChunk(ChatCompletionDelta{Role: llm.RoleAssistant, Content: chunk}, nil)
+		}
+		return writeChunk(ChatCompletionDelta{Content: chunk}, nil)
+	}, options...)
+	if err != nil {
+		log.Errorf("[SERVER] chat completion failed: %v", err)
+		if !started {
+			writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		}
+		// the status line has been sent already, just close the stream
+		return
+	}
+
+	stop := "stop"
+	if err := writeChunk(ChatCompletionDelta{}, &stop); err != nil {
+		log.Errorf("[SERVER] failed to write stream: %v", err)
+		return
+	}
+	if _, err := fmt.Fprint(w, "data: [DONE]\n\n"); err != nil {
+		log.Errorf("[SERVER] failed to write stream: %v", err)
+		return
+	}
+	flusher.Flush()
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	allModels, err := s.listModels("", s.cfg)
+	if err != nil {
+		if len(allModels) == 0 {
+			writeError(w, http.StatusBadGateway, "upstream_error", err.Error())
+			return
+		}
+		log.Warnf("Some engines failed to list their models: %v", err)
+	}
+
+	engineNames := make([]string, 0, len(allModels))
+	for engineName := range allModels {
+		engineNames = append(engineNames, engineName)
+	}
+	sort.Strings(engineNames)
+
+	resp := ModelListResponse{Object: "list", Data: []ModelObject{}}
+	for _, engineName := range engineNames {
+		for _, modelName := range allModels[engineName] {
+			resp.Data = append(resp.Data, ModelObject{
+				ID:      engineName + "/" + modelName,
+				Object:  "model",
+				OwnedBy: engineName,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// resolveModel splits an "engine/model" identifier, or resolves a model alias. Model names
+// without a known engine prefix are routed to the default engine.
+func (s *Server) resolveModel(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return s.defaultEngine, s.defaultModel
+	}
+	if _, ok := s.cfg.Aliases.Lookup(name); ok {
+		return s.cfg.Aliases.Resolve(s.defaultEngine, name)
+	}
+	if prefix, rest, found := strings.Cut(name, "/"); found {
+		if _, ok := s.cfg.LLMEngines[strings.ToLower(prefix)]; ok {
+			return strings.ToLower(prefix), rest
+		}
+	}
+	if _, ok := s.cfg.LLMEngines[strings.ToLower(name)]; ok {
+		return strings.ToLower(name), ""
+	}
+	return s.defaultEngine, name
+}
+
+// authenticate rejects the requests without the API key of the config, if any. The keys are compared in constant
+// time, so that the timing does not reveal how much of a key is right.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := []byte("Bearer " + s.cfg.Server.APIKey)
+		if s.cfg.Server.APIKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, "authentication_error", "invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, errType string, message string) {
+	var resp errorResponse
+	resp.Error.Message = message
+	resp.Error.Type = errType
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,265 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+	testee "github.com/robinmin/askllm/internal/server"
+)
+
+// echoEngine answers with the engine, model and last message
+func echoEngine(engineType, model string) *llmtest.Engine {
+	return llmtest.New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+		if engineType == "broken" {
+			return nil, fmt.Errorf("provider is down")
+		}
+		result := fmt.Sprintf("%s/%s: %s", engineType, model, messages[len(messages)-1].Content)
+		return &llm.Response{Content: result, Usage: llm.EstimateUsage(messages, result)}, nil
+	})
+}
+
+func newTestServer() (*config.Config, http.Handler) {
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"ollama": {Model: "gemma2"},
+			"groq":   {Model: "gemma2-9b-it"},
+			"broken": {},
+		},
+		Aliases: config.Aliases{"Fast": {Engine: "groq", Model: "llama3-8b"}, "local": {Model: "llama3"}},
+	}
+	cfg.Sys.DefaultEngine = "ollama"
+
+	srv := testee.NewServer(cfg, "", "gemma2").
+		WithEngineFactory(func(engineType, model string, cfg *config.Config) (llm.Engine, error) {
+			return echoEngine(engineType, model), nil
+		}).
+		WithModelLister(func(engineType string, cfg *config.Config) (map[string][]string, error) {
+			return map[string][]string{"ollama": {"gemma2"}, "groq": {"llama3-8b", "gemma2-9b-it"}}, nil
+		})
+	return cfg, srv.Handler()
+}
+
+func postChat(t *testing.T, handler http.Handler, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_ChatCompletions(t *testing.T) {
+	_, handler := newTestServer()
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		expected string
+	}{
+		{"EnginePrefix", `{"model":"groq/llama3-8b","messages":[{"role":"user","content":"hi"}]}`, http.StatusOK, "groq/llama3-8b: hi"},
+		{"DefaultEngine", `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`, http.StatusOK, "ollama/llama3: hi"},
+		{"EmptyModel", `{"messages":[{"role":"user","content":"hi"}]}`, http.StatusOK, "ollama/gemma2: hi"},
+		{"Alias", `{"model":"fast","messages":[{"role":"user","content":"hi"}]}`, http.StatusOK, "groq/llama3-8b: hi"},
+		{"AliasWithoutEngine", `{"model":"local","messages":[{"role":"user","content":"hi"}]}`, http.StatusOK, "ollama/llama3: hi"},
+		{"EngineOnly", `{"model":"groq","messages":[{"role":"user","content":"hi"}]}`, http.StatusOK, "groq/: hi"},
+		{"ContentParts", `{"model":"groq/llama3-8b","messages":[{"role":"user","content":[{"type":"text","text":"hi"},{"type":"text","text":"there"}]}]}`, http.StatusOK, "groq/llama3-8b: hi\nthere"},
+		{"NullContent", `{"model":"groq/llama3-8b","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":null},{"role":"user","content":"again"}]}`, http.StatusOK, "groq/llama3-8b: again"},
+		{"ImageContent", `{"model":"groq/llama3-8b","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"x"}}]}]}`, http.StatusBadRequest, ""},
+		{"NoMessages", `{"model":"groq/llama3-8b","messages":[]}`, http.StatusBadRequest, ""},
+		{"InvalidBody", `not json`, http.StatusBadRequest, ""},
+		{"UpstreamError", `{"model":"broken/x","messages":[{"role":"user","content":"hi"}]}`, http.StatusBadGateway, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postChat(t, handler, tt.body, nil)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp testee.ChatCompletionResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, "chat.completion", resp.Object)
+			assert.Len(t, resp.Choices, 1)
+			assert.Equal(t, llm.RoleAssistant, resp.Choices[0].Message.Role)
+			assert.Equal(t, tt.expected, resp.Choices[0].Message.Content)
+			assert.Positive(t, resp.Usage.CompletionTokens)
+			assert.Equal(t, resp.Usage.PromptTokens+resp.Usage.CompletionTokens, resp.Usage.TotalTokens)
+		})
+	}
+}
+
+func TestServer_ChatCompletionsParams(t *testing.T) {
+	cfg, _ := newTestServer()
+	engine := echoEngine("groq", "llama3-8b")
+	handler := testee.NewServer(cfg, "", "").
+		WithEngineFactory(func(engineType, model string, cfg *config.Config) (llm.Engine, error) {
+			return engine, nil
+		}).Handler()
+
+	body := `{"model":"groq/llama3-8b","messages":[{"role":"user","content":"hi"}],"temperature":0.7,"top_p":0.9,"max_tokens":64,"stop":"END","seed":42}`
+	rec := postChat(t, handler, body, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	temperature, topP := 0.7, 0.9
+	maxTokens, seed := 64, 42
+	assert.Equal(t, config.GenerationParams{Temperature: &temperature, TopP: &topP, MaxTokens: &maxTokens, Stop: []string{"END"}, Seed: &seed},
+		engine.LastCall().Options.Params(config.GenerationParams{}))
+
+	// the request parameters override the ones of the engine config
+	engineTemperature := 0.1
+	rec = postChat(t, handler, `{"model":"groq/llama3-8b","stream":true,"messages":[{"role":"user","content":"hi"}],"stop":["a","b"]}`, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	params := engine.LastCall().Options.Params(config.GenerationParams{Temperature: &engineTemperature, Stop: []string{"c"}})
+	assert.Equal(t, []string{"a", "b"}, params.Stop)
+	assert.Equal(t, &engineTemperature, params.Temperature)
+
+	rec = postChat(t, handler, `{"model":"groq/llama3-8b","messages":[{"role":"user","content":"hi"}],"stop":1}`, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_ChatCompletionsStream(t *testing.T) {
+	_, handler := newTestServer()
+
+	rec := postChat(t, handler, `{"model":"groq/llama3-8b","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	var content strings.Builder
+	var finishReason string
+	events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+	assert.Equal(t, "data: [DONE]", events[len(events)-1])
+	for _, event := range events[:len(events)-1] {
+		var chunk testee.ChatCompletionChunk
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk))
+		assert.Equal(t, "chat.completion.chunk", chunk.Object)
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != nil {
+			finishReason = *chunk.Choices[0].FinishReason
+		}
+	}
+	assert.Equal(t, "groq/llama3-8b: hi", content.String())
+	assert.Equal(t, "stop", finishReason)
+}
+
+func TestServer_Models(t *testing.T) {
+	_, handler := newTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var resp testee.ModelListResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "list", resp.Object)
+
+	var ids []string
+	for _, m := range resp.Data {
+		ids = append(ids, m.ID)
+	}
+	assert.Equal(t, []string{"groq/llama3-8b", "groq/gemma2-9b-it", "ollama/gemma2"}, ids)
+}
+
+func TestServer_ModelsFailure(t *testing.T) {
+	for name, tc := range map[string]struct {
+		models map[string][]string
+		status int
+	}{
+		"Partial": {map[string][]string{"ollama": {"gemma2"}}, http.StatusOK},
+		"All":     {map[string][]string{}, http.StatusBadGateway},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := testee.NewServer(&config.Config{}, "", "").
+				WithModelLister(func(engineType string, cfg *config.Config) (map[string][]string, error) {
+					return tc.models, fmt.Errorf("groq: connection refused")
+				}).Handler()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.status, rec.Code)
+			if tc.status == http.StatusOK {
+				assert.Contains(t, rec.Body.String(), "ollama/gemma2")
+			}
+		})
+	}
+}
+
+func TestServer_APIKey(t *testing.T) {
+	cfg, handler := newTestServer()
+	cfg.Server.APIKey = "secret"
+	body := `{"model":"groq/llama3-8b","messages":[{"role":"user","content":"hi"}]}`
+
+	rec := postChat(t, handler, body, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = postChat(t, handler, body, map[string]string{"Authorization": "Bearer secre"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = postChat(t, handler, body, map[string]string{"Authorization": "Bearer secret"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestPluginHelper is not a real test, but the plugin launched by TestServer_ClosesEngine from the test binary. It
+// writes its pid to the file given in ASKLLM_PLUGIN_PID, and echoes the prompts.
+func TestPluginHelper(t *testing.T) {
+	pidFile := os.Getenv("ASKLLM_PLUGIN_PID")
+	if pidFile == "" {
+		t.Skip("only run as a plugin process")
+	}
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req llm.PluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+		data, _ := json.Marshal(llm.PluginResponse{ID: req.ID, Type: llm.PluginTypeResult, Content: "echo: " + req.Messages[len(req.Messages)-1].Content})
+		fmt.Println(string(data))
+	}
+	os.Exit(0)
+}
+
+func TestServer_ClosesEngine(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "plugin.pid")
+	t.Setenv("ASKLLM_PLUGIN_PID", pidFile)
+	cfg := &config.Config{
+		LLMEngines: map[string]config.LLMEngineConfig{
+			"wrapper": {Kind: "plugin", Command: []string{os.Args[0], "-test.run=^TestPluginHelper$"}},
+		},
+	}
+	handler := testee.NewServer(cfg, "wrapper", "").Handler()
+
+	rec := postChat(t, handler, `{"model":"wrapper/echo","messages":[{"role":"user","content":"hi"}]}`, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "echo: hi")
+
+	data, err := os.ReadFile(pidFile)
+	assert.NoError(t, err)
+	pid, err := strconv.Atoi(string(data))
+	assert.NoError(t, err)
+	// the plugin has exited and been waited for once the request is served
+	assert.ErrorIs(t, syscall.Kill(pid, 0), syscall.ESRCH)
+}
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils"
+)
+
+const fileExt = ".yaml"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Turn is a single client run within a session
+type Turn struct {
+	Prompt     string    `yaml:"prompt"`           // Direct prompt or variables from the command line
+	PromptFile string    `yaml:"prompt_file"`      // Prompt file used to render the prompt
+	System     string    `yaml:"system,omitempty"` // System prompt sent along with the prompt
+	Rendered   string    `yaml:"rendered"`         // Prompt text sent to the LLM engine
+	Engine     string    `yaml:"engine"`           // LLM engine answered the prompt
+	Model      string    `yaml:"model"`            // LLM model answered the prompt
+	Response   string    `yaml:"response"`         // Response from the LLM engine
+	Usage      llm.Usage `yaml:"usage"`            // Token usage of the query
+	Cached     bool      `yaml:"cached,omitempty"` // Whether the response was served from the response cache
+	StartedAt  time.Time `yaml:"started_at"`       // Time the prompt was sent
+	FinishedAt time.Time `yaml:"finished_at"`      // Time the response was received
+}
+
+// Session is a named conversation persisted across client runs
+type Session struct {
+	Name      string    `yaml:"name"`       // Unique name of the session, also used as the file name
+	CreatedAt time.Time `yaml:"created_at"` // Time the session was created
+	UpdatedAt time.Time `yaml:"updated_at"` // Time the last turn was added
+	Turns     []Turn    `yaml:"turns"`      // All turns of the conversation
+}
+
+func NewSession(name string) *Session {
+	if name == "" {
+		name = time.Now().Format("20060102-150405.000")
+	}
+	now := time.Now()
+	return &Session{
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// AddTurn appends a turn to the session
+func (s *Session) AddTurn(turn Turn) {
+	s.Turns = append(s.Turns, turn)
+	s.UpdatedAt = turn.FinishedAt
+}
+
+// Messages replays the prior turns as the conversation context
+func (s *Session) Messages() []llm.Message {
+	messages := make([]llm.Message, 0, len(s.Turns)*2)
+	for _, turn := range s.Turns {
+		messages = append(messages,
+			llm.Message{Role: llm.RoleUser, Content: turn.Rendered},
+			llm.Message{Role: llm.RoleAssistant, Content: turn.Response},
+		)
+	}
+	return messages
+}
+
+// Markdown renders the whole session as markdown
+func (s *Session) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Session %s\n\n", s.Name))
+	sb.WriteString(fmt.Sprintf("Created at %s, updated at %s\n\n", s.CreatedAt.Format(time.DateTime), s.UpdatedAt.Format(time.DateTime)))
+	for i, turn := range s.Turns {
+		sb.WriteString(fmt.Sprintf("## Turn %d (%s/%s, %s)\n\n", i+1, turn.Engine, turn.Model, turn.StartedAt.Format(time.DateTime)))
+		if turn.System != "" {
+			sb.WriteString("### System\n\n" + strings.TrimSpace(turn.System) + "\n\n")
+		}
+		sb.WriteString("### Prompt\n\n" + strings.TrimSpace(turn.Rendered) + "\n\n")
+		sb.WriteString("### Response\n\n" + strings.TrimSpace(turn.Response) + "\n\n")
+	}
+	return sb.String()
+}
+
+// Store persists sessions as YAML files in a folder
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		dir = config.DefaultSessionPath
+	}
+	absolutePath, err := config.ExpandTilde(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absolutePath, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session folder %s: %v", absolutePath, err)
+	}
+	return &Store{dir: absolutePath}, nil
+}
+
+func (st *Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid session name: %q", name)
+	}
+	return filepath.Join(st.dir, name+fileExt), nil
+}
+
+func (st *Store) Load(name string) (*Session, error) {
+	file, err := st.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, fmt.Errorf("session not found: %s", name)
+	}
+	return utils.LoadConfig[Session](file)
+}
+
+// LoadOrCreate loads the named session, or creates a new one if it does not exist yet
+func (st *Store) LoadOrCreate(name string) (*Session, error) {
+	file, err := st.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return NewSession(name), nil
+	}
+	return utils.LoadConfig[Session](file)
+}
+
+func (st *Store) Save(s *Session) error {
+	file, err := st.path(s.Name)
+	if err != nil {
+		return err
+	}
+	return utils.SaveConfig(s, file)
+}
+
+func (st *Store) Delete(name string) error {
+	file, err := st.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("session not found: %s", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// List returns all sessions, the most recently updated first
+func (st *Store) List() ([]*Session, error) {
+	files, err := filepath.Glob(filepath.Join(st.dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(files))
+	for _, file := range files {
+		s, err := utils.LoadConfig[Session](file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load session %s: %v", file, err)
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// Last returns the most recently updated session
+func (st *Store) Last() (*Session, error) {
+	sessions, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no session found")
+	}
+	return sessions[0], nil
+}
@@ -0,0 +1,87 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/llm"
+	testee "github.com/robinmin/askllm/internal/session"
+)
+
+func newTurn(prompt string, response string, finishedAt time.Time) testee.Turn {
+	return testee.Turn{
+		Prompt:     prompt,
+		Rendered:   prompt,
+		Engine:     "ollama",
+		Model:      "gemma2",
+		Response:   response,
+		StartedAt:  finishedAt.Add(-time.Second),
+		FinishedAt: finishedAt,
+	}
+}
+
+func TestSession_Messages(t *testing.T) {
+	sess := testee.NewSession("test")
+	sess.AddTurn(newTurn("hello", "hi", time.Now()))
+	sess.AddTurn(newTurn("how are you", "fine", time.Now()))
+
+	expected := []llm.Message{
+		{Role: llm.RoleUser, Content: "hello"},
+		{Role: llm.RoleAssistant, Content: "hi"},
+		{Role: llm.RoleUser, Content: "how are you"},
+		{Role: llm.RoleAssistant, Content: "fine"},
+	}
+	assert.Equal(t, expected, sess.Messages())
+	assert.Contains(t, sess.Markdown(), "## Turn 2 (ollama/gemma2")
+}
+
+func TestStore(t *testing.T) {
+	store, err := testee.NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		sess, err := store.LoadOrCreate("first")
+		assert.NoError(t, err)
+		assert.Empty(t, sess.Turns)
+
+		sess.AddTurn(newTurn("hello", "hi", time.Now().Add(-time.Hour)))
+		assert.NoError(t, store.Save(sess))
+
+		loaded, err := store.Load("first")
+		assert.NoError(t, err)
+		assert.Equal(t, "first", loaded.Name)
+		assert.Len(t, loaded.Turns, 1)
+		assert.Equal(t, "hi", loaded.Turns[0].Response)
+		assert.True(t, sess.UpdatedAt.Equal(loaded.UpdatedAt))
+	})
+
+	t.Run("ListAndLast", func(t *testing.T) {
+		sess := testee.NewSession("second")
+		sess.AddTurn(newTurn("hello", "hi", time.Now()))
+		assert.NoError(t, store.Save(sess))
+
+		sessions, err := store.List()
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 2)
+		assert.Equal(t, "second", sessions[0].Name)
+
+		last, err := store.Last()
+		assert.NoError(t, err)
+		assert.Equal(t, "second", last.Name)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, store.Delete("second"))
+		assert.Error(t, store.Delete("second"))
+
+		_, err := store.Load("second")
+		assert.Error(t, err)
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		_, err := store.Load("../config")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,57 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/robinmin/askllm/internal/llm"
+)
+
+// Run asks the engine with the tools of the toolbox, runs the tools called by the model and sends their results
+// back, until the model answers or the maximum number of rounds of tool calls of the config is reached. The answer
+// is passed to onChunk (if not nil) at once. The messages of the exchange (tool calls, tool results and answer) are
+// returned along with the answer, whose usage sums up all rounds.
+func Run(engine llm.Engine, messages []llm.Message, tb *Toolbox, onChunk llm.StreamFunc, options ...llm.CallOption) (*llm.Response, []llm.Message, error) {
+	maxSteps := tb.maxSteps
+	ctx := llm.NewCallOptions(options...).Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	conversation := make([]llm.Message, 0, len(messages)+maxSteps*2)
+	conversation = append(conversation, messages...)
+	options = append(options[:len(options):len(options)], llm.WithTools(tb.Tools()...))
+
+	var usage llm.Usage
+	for step := 0; step <= maxSteps; step++ {
+		response, err := llm.Chat(engine, conversation, options...)
+		if err != nil {
+			return nil, nil, err
+		}
+		usage = usage.Add(response.Usage)
+
+		if len(response.ToolCalls) == 0 {
+			if onChunk != nil && response.Content != "" {
+				if err := onChunk(response.Content); err != nil {
+					return nil, nil, err
+				}
+			}
+			response.Usage = usage
+			conversation = append(conversation, llm.Message{Role: llm.RoleAssistant, Content: response.Content})
+			return response, conversation[len(messages):], nil
+		}
+		if step == maxSteps {
+			break
+		}
+
+		conversation = append(conversation, llm.Message{Role: llm.RoleAssistant, Content: response.Content, ToolCalls: response.ToolCalls})
+		for _, call := range response.ToolCalls {
+			result := tb.Call(ctx, call)
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			conversation = append(conversation, llm.Message{Role: llm.RoleTool, Content: result, ToolCallID: call.ID})
+		}
+	}
+	return nil, nil, fmt.Errorf("no answer after %d rounds of tool calls", maxSteps)
+}
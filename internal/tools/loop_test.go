@@ -0,0 +1,58 @@
+package tools_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/llm/llmtest"
+	testee "github.com/robinmin/askllm/internal/tools"
+)
+
+// toolCaller calls the tool on every query until it has been called the given number of times, then answers
+// with the last tool result
+func toolCaller(calls int) *llmtest.Engine {
+	return llmtest.New(func(messages []llm.Message, options llm.CallOptions) (*llm.Response, error) {
+		usage := llm.Usage{PromptTokens: 10, CompletionTokens: 1}
+		if calls > 0 {
+			calls--
+			return &llm.Response{ToolCalls: []llm.ToolCall{llm.NewToolCall("call_1", testee.ReadFile, `{"path":"go.mod"}`)}, Usage: usage}, nil
+		}
+		return &llm.Response{Content: "answer: " + messages[len(messages)-1].Content, Usage: usage}, nil
+	})
+}
+
+func TestRun(t *testing.T) {
+	cfg := newConfig(testee.ReadFile)
+	cfg.Tools.MaxSteps = 2
+	tb, err := testee.NewToolbox(cfg, newWorkspace(t), nil)
+	assert.NoError(t, err)
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "which module?"}}
+
+	t.Run("Answer", func(t *testing.T) {
+		engine := toolCaller(1)
+		var chunks []string
+		response, exchange, err := testee.Run(engine, messages, tb, func(chunk string) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "answer: module example.com/demo\n\ngo 1.22\n", response.Content)
+		assert.Equal(t, []string{response.Content}, chunks)
+		assert.Equal(t, llm.Usage{PromptTokens: 20, CompletionTokens: 2}, response.Usage)
+		assert.Len(t, engine.LastCall().Options.Tools, 4)
+
+		assert.Len(t, exchange, 3)
+		assert.Equal(t, llm.RoleAssistant, exchange[0].Role)
+		assert.Len(t, exchange[0].ToolCalls, 1)
+		assert.Equal(t, llm.Message{Role: llm.RoleTool, Content: "module example.com/demo\n\ngo 1.22\n", ToolCallID: "call_1"}, exchange[1])
+		assert.Equal(t, llm.Message{Role: llm.RoleAssistant, Content: response.Content}, exchange[2])
+		assert.Len(t, messages, 1)
+	})
+
+	t.Run("TooManySteps", func(t *testing.T) {
+		_, _, err := testee.Run(toolCaller(5), messages, tb, nil)
+		assert.EqualError(t, err, "no answer after 2 rounds of tool calls")
+	})
+}
@@ -0,0 +1,433 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/pkg/utils/log"
+)
+
+// Names of the built-in tools
+const (
+	ReadFile      = "read_file"
+	ListDirectory = "list_directory"
+	Grep          = "grep"
+	RunCommand    = "run_command"
+)
+
+const (
+	maxGrepMatches  = 200     // Maximum number of lines reported by grep
+	maxGrepFileSize = 1 << 20 // Files larger than it are skipped by grep
+)
+
+// execFlags are the flags making an allowed command run another program, e.g. go test -exec, refused after the
+// words of the allowed command
+var execFlags = []string{"exec", "execdir", "toolexec", "vettool"}
+
+// Decision is the answer of the user to run a tool call
+type Decision int
+
+const (
+	Deny        Decision = iota // Do not run the call
+	Allow                       // Run the call
+	AllowAlways                 // Run the call, and the later calls of the same tool without asking
+)
+
+// ConfirmFunc asks the user whether the tool call may run
+type ConfirmFunc func(call llm.ToolCall) (Decision, error)
+
+// TerminalConfirm asks the user on the terminal to run every tool call, a yes or always answer allowing it
+func TerminalConfirm(in io.Reader, out io.Writer) ConfirmFunc {
+	reader := bufio.NewReader(in)
+	return func(call llm.ToolCall) (Decision, error) {
+		fmt.Fprintf(out, "\nRun %s %s? [y]es, [n]o, [a]lways: ", call.Function.Name, call.Function.Arguments)
+		answer, err := reader.ReadString('\n')
+		if err != nil && answer == "" {
+			return Deny, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return Allow, nil
+		case "a", "always":
+			return AllowAlways, nil
+		default:
+			return Deny, nil
+		}
+	}
+}
+
+// builtin is a local tool, run with the JSON arguments given by the model
+type builtin struct {
+	def llm.Tool
+	run func(ctx context.Context, arguments []byte) (string, error)
+}
+
+// Toolbox runs the built-in tools called by the model within the working directory. Every call requires the
+// confirmation of the user, unless the tool is approved by the config.
+type Toolbox struct {
+	root      string // Working directory, the files outside it are refused
+	commands  [][]string
+	approved  map[string]bool
+	maxSteps  int // Maximum number of rounds of tool calls of a query
+	maxOutput int
+	confirm   ConfirmFunc
+	tools     map[string]builtin
+	names     []string // Names of the tools in the order of definition
+}
+
+// NewToolbox creates the toolbox of the working directory root. The run_command tool is only offered if some
+// commands are allowed by the config. A nil confirm denies the calls of the tools not approved.
+func NewToolbox(cfg *config.Config, root string, confirm ConfirmFunc) (*Toolbox, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+
+	t := &Toolbox{
+		root:      root,
+		approved:  map[string]bool{},
+		maxSteps:  cfg.Tools.MaxSteps,
+		maxOutput: cfg.Tools.MaxOutput,
+		confirm:   confirm,
+		tools:     map[string]builtin{},
+	}
+	if t.maxSteps <= 0 {
+		t.maxSteps = config.DefaultToolSteps
+	}
+	if t.maxOutput <= 0 {
+		t.maxOutput = config.DefaultToolOutput
+	}
+	for _, name := range cfg.Tools.Approved {
+		t.approved[strings.TrimSpace(strings.ToLower(name))] = true
+	}
+	for _, command := range cfg.Tools.Commands {
+		if fields := strings.Fields(command); len(fields) > 0 {
+			t.commands = append(t.commands, fields)
+		}
+	}
+
+	t.add(llm.Tool{
+		Name:        ReadFile,
+		Description: "Read the content of a text file in the working directory",
+		Parameters:  objectSchema([]string{"path"}, stringProperty("path", "Path of the file, relative to the working directory")),
+	}, t.readFile)
+	t.add(llm.Tool{
+		Name:        ListDirectory,
+		Description: "List the entries of a directory in the working directory, sub-directories end with a slash",
+		Parameters:  objectSchema(nil, stringProperty("path", "Path of the directory, relative to the working directory; . by default")),
+	}, t.listDirectory)
+	t.add(llm.Tool{
+		Name:        Grep,
+		Description: "Search the text files of a directory recursively for the lines matching a regular expression, reported as path:line:text",
+		Parameters: objectSchema([]string{"pattern"},
+			stringProperty("pattern", "Regular expression of the RE2 syntax"),
+			stringProperty("path", "File or directory to search, relative to the working directory; . by default"),
+		),
+	}, t.grep)
+	if len(t.commands) > 0 {
+		allowed := make([]string, 0, len(t.commands))
+		for _, command := range t.commands {
+			allowed = append(allowed, strings.Join(command, " "))
+		}
+		t.add(llm.Tool{
+			Name:        RunCommand,
+			Description: "Run a command in the working directory without a shell and return its output. Allowed commands: " + strings.Join(allowed, "; "),
+			Parameters:  objectSchema([]string{"command"}, stringProperty("command", "Command line, the arguments separated by spaces")),
+		}, t.runCommand)
+	}
+	return t, nil
+}
+
+func (t *Toolbox) add(def llm.Tool, run func(ctx context.Context, arguments []byte) (string, error)) {
+	t.tools[def.Name] = builtin{def: def, run: run}
+	t.names = append(t.names, def.Name)
+}
+
+// Tools returns the definitions of the tools to give to the model
+func (t *Toolbox) Tools() []llm.Tool {
+	defs := make([]llm.Tool, 0, len(t.names))
+	for _, name := range t.names {
+		defs = append(defs, t.tools[name].def)
+	}
+	return defs
+}
+
+// Call runs the tool call once confirmed, and returns the result to send back to the model. Failures, including
+// unknown tools and denied calls, are reported to the model as the result.
+func (t *Toolbox) Call(ctx context.Context, call llm.ToolCall) string {
+	name := call.Function.Name
+	log.Infof("[TOOL] %s %s", name, call.Function.Arguments)
+
+	tool, ok := t.tools[name]
+	if !ok {
+		log.Warnf("[TOOL] %s is unknown", name)
+		return "error: unknown tool " + name
+	}
+	if !t.approved[name] {
+		decision := Deny
+		if t.confirm != nil {
+			var err error
+			if decision, err = t.confirm(call); err != nil {
+				log.Warnf("[TOOL] %s not confirmed: %v", name, err)
+				return "error: the call was not confirmed by the user"
+			}
+		}
+		switch decision {
+		case AllowAlways:
+			t.approved[name] = true
+		case Deny:
+			log.Warnf("[TOOL] %s denied", name)
+			return "error: the call was denied by the user"
+		}
+	}
+
+	result, err := tool.run(ctx, []byte(call.Function.Arguments))
+	if err != nil {
+		log.Warnf("[TOOL] %s failed: %v", name, err)
+		return "error: " + err.Error()
+	}
+	log.Infof("[TOOL] %s returned %d bytes", name, len(result))
+	return t.truncate(result)
+}
+
+// truncate cuts the result beyond the output limit
+func (t *Toolbox) truncate(result string) string {
+	if len(result) <= t.maxOutput {
+		return result
+	}
+	return fmt.Sprintf("%s\n... (truncated, %d bytes in total)", result[:t.maxOutput], len(result))
+}
+
+// resolve returns the absolute path of the path relative to the working directory, refusing the paths outside it
+func (t *Toolbox) resolve(path string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		path = "."
+	}
+	absolute := path
+	if !filepath.IsAbs(path) {
+		absolute = filepath.Join(t.root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(absolute)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(t.root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the working directory", path)
+	}
+	return resolved, nil
+}
+
+// relative returns the path relative to the working directory for display
+func (t *Toolbox) relative(path string) string {
+	if rel, err := filepath.Rel(t.root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// toolArgs are the arguments of all built-in tools
+type toolArgs struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Command string `json:"command"`
+}
+
+func parseArgs(arguments []byte) (toolArgs, error) {
+	var args toolArgs
+	if len(bytes.TrimSpace(arguments)) == 0 {
+		return args, nil
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return args, fmt.Errorf("invalid arguments: %v", err)
+	}
+	return args, nil
+}
+
+func (t *Toolbox) readFile(ctx context.Context, arguments []byte) (string, error) {
+	args, err := parseArgs(arguments)
+	if err != nil {
+		return "", err
+	}
+	if args.Path == "" {
+		return "", fmt.Errorf("no path given")
+	}
+	path, err := t.resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (t *Toolbox) listDirectory(ctx context.Context, arguments []byte) (string, error) {
+	args, err := parseArgs(arguments)
+	if err != nil {
+		return "", err
+	}
+	path, err := t.resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(entry.Name())
+		if entry.IsDir() {
+			sb.WriteString("/")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func (t *Toolbox) grep(ctx context.Context, arguments []byte) (string, error) {
+	args, err := parseArgs(arguments)
+	if err != nil {
+		return "", err
+	}
+	pattern, err := regexp.Compile(args.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %v", err)
+	}
+	path, err := t.resolve(args.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	errFull := errors.New("too many matches")
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip the unreadable entries
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.IsDir() {
+			// skip the hidden folders, e.g. .git
+			if file != path && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := entry.Info(); err != nil || !info.Mode().IsRegular() || info.Size() > maxGrepFileSize {
+			return nil
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil || bytes.IndexByte(data[:min(len(data), 512)], 0) >= 0 {
+			return nil // skip the unreadable and binary files
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), maxGrepFileSize)
+		for line := 1; scanner.Scan(); line++ {
+			if pattern.Match(scanner.Bytes()) {
+				matches = append(matches, fmt.Sprintf("%s:%d:%s", t.relative(file), line, scanner.Text()))
+				if len(matches) == maxGrepMatches {
+					return errFull
+				}
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errFull):
+		matches = append(matches, fmt.Sprintf("... (stopped after %d matches)", maxGrepMatches))
+	case err != nil:
+		return "", err
+	case len(matches) == 0:
+		return "no matches", nil
+	}
+	return strings.Join(matches, "\n"), nil
+}
+
+func (t *Toolbox) runCommand(ctx context.Context, arguments []byte) (string, error) {
+	args, err := parseArgs(arguments)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no command given")
+	}
+	if !t.allowed(fields) {
+		return "", fmt.Errorf("command not allowed: %s", args.Command)
+	}
+
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Dir = t.root
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// a failed command is a result for the model, e.g. failing tests
+		return fmt.Sprintf("%s\n(exit status %d)", out, exitErr.ExitCode()), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// allowed reports whether the command starts with the words of an allowed command, and is not given any flag of
+// execFlags after them
+func (t *Toolbox) allowed(fields []string) bool {
+	for _, command := range t.commands {
+		if len(fields) >= len(command) && slices.Equal(fields[:len(command)], command) {
+			return !slices.ContainsFunc(fields[len(command):], isExecFlag)
+		}
+	}
+	return false
+}
+
+// isExecFlag reports whether the argument is a flag of execFlags, with one or two dashes and with or without a value
+func isExecFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return slices.Contains(execFlags, name)
+}
+
+// objectSchema returns the JSON schema of an object with the properties
+func objectSchema(required []string, properties ...map[string]any) map[string]any {
+	props := map[string]any{}
+	for _, property := range properties {
+		for name, schema := range property {
+			props[name] = schema
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func stringProperty(name, description string) map[string]any {
+	return map[string]any{name: map[string]any{"type": "string", "description": description}}
+}
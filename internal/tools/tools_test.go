@@ -0,0 +1,145 @@
+package tools_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	testee "github.com/robinmin/askllm/internal/tools"
+)
+
+// newWorkspace creates a working directory with a few files
+func newWorkspace(t *testing.T) string {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "pkg", ".git"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/demo\n\ngo 1.22\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "pkg", "demo.go"), []byte("package pkg\n\nfunc Demo() {}\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "pkg", ".git", "HEAD"), []byte("func Hidden\n"), 0o644))
+	return root
+}
+
+func newConfig(approved ...string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Tools.Approved = approved
+	cfg.Tools.Commands = []string{"echo hello"}
+	return cfg
+}
+
+func call(name, arguments string) llm.ToolCall {
+	return llm.NewToolCall("call_1", name, arguments)
+}
+
+func TestToolbox(t *testing.T) {
+	root := newWorkspace(t)
+	tb, err := testee.NewToolbox(newConfig(testee.ReadFile, testee.ListDirectory, testee.Grep, testee.RunCommand), root, nil)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	var names []string
+	for _, tool := range tb.Tools() {
+		names = append(names, tool.Name)
+	}
+	assert.Equal(t, []string{testee.ReadFile, testee.ListDirectory, testee.Grep, testee.RunCommand}, names)
+
+	tests := []struct {
+		name      string
+		call      llm.ToolCall
+		expected  string
+		substring bool
+	}{
+		{"ReadFile", call(testee.ReadFile, `{"path":"go.mod"}`), "module example.com/demo\n\ngo 1.22\n", false},
+		{"ReadMissing", call(testee.ReadFile, `{"path":"missing.txt"}`), "error: ", true},
+		{"ReadOutside", call(testee.ReadFile, `{"path":"../secret"}`), "error: ", true},
+		{"ReadAbsoluteOutside", call(testee.ReadFile, `{"path":"/etc/hosts"}`), "is outside of the working directory", true},
+		{"InvalidArguments", call(testee.ReadFile, `not json`), "error: invalid arguments", true},
+		{"ListDirectory", call(testee.ListDirectory, `{}`), "go.mod\npkg/\n", false},
+		{"Grep", call(testee.Grep, `{"pattern":"^func "}`), "pkg/demo.go:3:func Demo() {}", false},
+		{"GrepNoMatch", call(testee.Grep, `{"pattern":"nothing here","path":"pkg"}`), "no matches", false},
+		{"RunCommand", call(testee.RunCommand, `{"command":"echo hello world"}`), "hello world\n", false},
+		{"CommandNotAllowed", call(testee.RunCommand, `{"command":"echo bye"}`), "error: command not allowed: echo bye", false},
+		{"CommandExec", call(testee.RunCommand, `{"command":"echo hello -exec /bin/sh"}`), "error: command not allowed: echo hello -exec /bin/sh", false},
+		{"CommandToolexec", call(testee.RunCommand, `{"command":"echo hello --toolexec=/bin/sh"}`), "error: command not allowed: echo hello --toolexec=/bin/sh", false},
+		{"UnknownTool", call("write_file", `{}`), "error: unknown tool write_file", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tb.Call(ctx, tt.call)
+			if tt.substring {
+				assert.Contains(t, result, tt.expected)
+			} else {
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestToolbox_Confirm(t *testing.T) {
+	root := newWorkspace(t)
+	ctx := context.Background()
+
+	t.Run("NoCommands", func(t *testing.T) {
+		tb, err := testee.NewToolbox(&config.Config{}, root, nil)
+		assert.NoError(t, err)
+		assert.Len(t, tb.Tools(), 3)
+	})
+
+	t.Run("DeniedWithoutConfirmation", func(t *testing.T) {
+		tb, err := testee.NewToolbox(newConfig(), root, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "error: the call was denied by the user", tb.Call(ctx, call(testee.ReadFile, `{"path":"go.mod"}`)))
+	})
+
+	t.Run("Decisions", func(t *testing.T) {
+		answers := []testee.Decision{testee.Deny, testee.Allow, testee.AllowAlways}
+		var asked []string
+		tb, err := testee.NewToolbox(newConfig(), root, func(call llm.ToolCall) (testee.Decision, error) {
+			asked = append(asked, call.Function.Name)
+			decision := answers[0]
+			answers = answers[1:]
+			return decision, nil
+		})
+		assert.NoError(t, err)
+
+		assert.Contains(t, tb.Call(ctx, call(testee.ListDirectory, `{}`)), "denied")
+		assert.Equal(t, "go.mod\npkg/\n", tb.Call(ctx, call(testee.ListDirectory, `{}`)))
+		assert.Equal(t, "go.mod\npkg/\n", tb.Call(ctx, call(testee.ListDirectory, `{}`)))
+		// approved for the rest of the run
+		assert.Equal(t, "go.mod\npkg/\n", tb.Call(ctx, call(testee.ListDirectory, `{}`)))
+		assert.Equal(t, []string{testee.ListDirectory, testee.ListDirectory, testee.ListDirectory}, asked)
+	})
+
+	t.Run("ConfirmationFailed", func(t *testing.T) {
+		tb, err := testee.NewToolbox(newConfig(), root, func(call llm.ToolCall) (testee.Decision, error) {
+			return testee.Allow, errors.New("no terminal")
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "error: the call was not confirmed by the user", tb.Call(ctx, call(testee.ListDirectory, `{}`)))
+	})
+
+	t.Run("Terminal", func(t *testing.T) {
+		var out strings.Builder
+		confirm := testee.TerminalConfirm(strings.NewReader("y\nnope\nAlways\n"), &out)
+		for _, expected := range []testee.Decision{testee.Allow, testee.Deny, testee.AllowAlways, testee.Deny} {
+			decision, _ := confirm(call(testee.Grep, `{"pattern":"x"}`))
+			assert.Equal(t, expected, decision)
+		}
+		assert.Contains(t, out.String(), `Run grep {"pattern":"x"}? [y]es, [n]o, [a]lways: `)
+	})
+}
+
+func TestToolbox_MaxOutput(t *testing.T) {
+	cfg := newConfig(testee.ReadFile)
+	cfg.Tools.MaxOutput = 6
+	tb, err := testee.NewToolbox(cfg, newWorkspace(t), nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "module\n... (truncated, 33 bytes in total)", tb.Call(context.Background(), call(testee.ReadFile, `{"path":"go.mod"}`)))
+}
@@ -0,0 +1,165 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/session"
+)
+
+// Record is the usage of a single query
+type Record struct {
+	Engine  string
+	Model   string
+	Usage   llm.Usage
+	Latency time.Duration
+	Cached  bool // Served from the response cache
+}
+
+// Summary is the aggregated usage of an engine/model pair
+type Summary struct {
+	Engine           string
+	Model            string
+	Queries          int // Number of queries answered by the engine
+	Cached           int // Number of queries served from the response cache
+	PromptTokens     int
+	CompletionTokens int
+	Estimated        int     // Number of queries with estimated usage
+	Cost             float64 // Estimated cost in USD
+	Priced           bool    // Whether the price of the model is configured
+	Latency          time.Duration
+}
+
+// FromSessions collects the usage records of all turns of the sessions
+func FromSessions(sessions []*session.Session) []Record {
+	var records []Record
+	for _, sess := range sessions {
+		for _, turn := range sess.Turns {
+			records = append(records, Record{
+				Engine:  turn.Engine,
+				Model:   turn.Model,
+				Usage:   turn.Usage,
+				Latency: turn.FinishedAt.Sub(turn.StartedAt),
+				Cached:  turn.Cached,
+			})
+		}
+	}
+	return records
+}
+
+// Cost estimates the cost in USD of the usage, and reports whether the price of the model is configured
+func Cost(cfg *config.Config, engine, model string, usage llm.Usage) (float64, bool) {
+	price, ok := cfg.Price(engine, model)
+	if !ok {
+		return 0, false
+	}
+	return price.Cost(usage.PromptTokens, usage.CompletionTokens), true
+}
+
+// Footer summarizes the usage of a single query in one line
+func Footer(cfg *config.Config, record Record) string {
+	tokens := fmt.Sprintf("%d prompt + %d completion = %d", record.Usage.PromptTokens, record.Usage.CompletionTokens, record.Usage.TotalTokens())
+	if record.Cached {
+		tokens += " (cached)"
+	} else if record.Usage.Estimated {
+		tokens += " (estimated)"
+	}
+
+	cost := "n/a"
+	if value, ok := Cost(cfg, record.Engine, record.Model, record.Usage); ok {
+		cost = fmt.Sprintf("$%.6f", value)
+	}
+	return fmt.Sprintf("Tokens: %s | Cost: %s | Latency: %s", tokens, cost, record.Latency.Round(time.Millisecond))
+}
+
+// Aggregate sums up the records per engine/model pair, sorted by engine and model
+func Aggregate(cfg *config.Config, records []Record) []Summary {
+	summaries := map[string]*Summary{}
+	for _, record := range records {
+		key := record.Engine + "/" + record.Model
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &Summary{Engine: record.Engine, Model: record.Model}
+			_, summary.Priced = cfg.Price(record.Engine, record.Model)
+			summaries[key] = summary
+		}
+
+		// the cache hits cost nothing, and would skew the average latency
+		if record.Cached {
+			summary.Cached++
+			continue
+		}
+		summary.Queries++
+		summary.PromptTokens += record.Usage.PromptTokens
+		summary.CompletionTokens += record.Usage.CompletionTokens
+		summary.Latency += record.Latency
+		if record.Usage.Estimated {
+			summary.Estimated++
+		}
+		if cost, ok := Cost(cfg, record.Engine, record.Model, record.Usage); ok {
+			summary.Cost += cost
+		}
+	}
+
+	result := make([]Summary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Engine != result[j].Engine {
+			return result[i].Engine < result[j].Engine
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
+
+// Report renders the summaries as a markdown table with a total row
+func Report(summaries []Summary) string {
+	lines := []string{
+		"| Engine/Model | Queries | Cached | Prompt tokens | Completion tokens | Estimated | Cost (USD) | Avg latency |",
+		"| --- | --- | --- | --- | --- | --- | --- | --- |",
+	}
+
+	var total Summary
+	allPriced := true
+	for _, summary := range summaries {
+		cost := "n/a"
+		if summary.Priced {
+			cost = fmt.Sprintf("%.4f", summary.Cost)
+		} else {
+			allPriced = false
+		}
+		lines = append(lines, fmt.Sprintf("| %s/%s | %d | %d | %d | %d | %d | %s | %s |",
+			summary.Engine, summary.Model, summary.Queries, summary.Cached, summary.PromptTokens, summary.CompletionTokens,
+			summary.Estimated, cost, averageLatency(summary)))
+
+		total.Queries += summary.Queries
+		total.Cached += summary.Cached
+		total.PromptTokens += summary.PromptTokens
+		total.CompletionTokens += summary.CompletionTokens
+		total.Estimated += summary.Estimated
+		total.Cost += summary.Cost
+		total.Latency += summary.Latency
+	}
+
+	// the total cost leaves out the models without price
+	totalCost := fmt.Sprintf("%.4f", total.Cost)
+	if !allPriced {
+		totalCost = ">= " + totalCost
+	}
+	lines = append(lines, fmt.Sprintf("| **Total** | %d | %d | %d | %d | %d | %s | %s |",
+		total.Queries, total.Cached, total.PromptTokens, total.CompletionTokens, total.Estimated, totalCost, averageLatency(total)))
+	return strings.Join(lines, "\n")
+}
+
+func averageLatency(summary Summary) time.Duration {
+	if summary.Queries == 0 {
+		return 0
+	}
+	return (summary.Latency / time.Duration(summary.Queries)).Round(time.Millisecond)
+}
@@ -0,0 +1,84 @@
+package usage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/robinmin/askllm/internal/config"
+	"github.com/robinmin/askllm/internal/llm"
+	"github.com/robinmin/askllm/internal/session"
+	testee "github.com/robinmin/askllm/internal/usage"
+)
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		Pricing: map[string]config.ModelPrice{
+			"gpt-4o-mini":       {Input: 0.15, Output: 0.6},
+			"groq/gemma2-9b-it": {Input: 0.2, Output: 0.2},
+		},
+	}
+}
+
+func TestCost(t *testing.T) {
+	cfg := newTestConfig()
+
+	cost, ok := testee.Cost(cfg, "chatgpt", "gpt-4o-mini", llm.Usage{PromptTokens: 1_000_000, CompletionTokens: 500_000})
+	assert.True(t, ok)
+	assert.InDelta(t, 0.45, cost, 1e-9)
+
+	_, ok = testee.Cost(cfg, "groq", "gemma2-9b-it", llm.Usage{})
+	assert.True(t, ok)
+
+	_, ok = testee.Cost(cfg, "ollama", "gemma2-9b-it", llm.Usage{})
+	assert.False(t, ok)
+}
+
+func TestFooter(t *testing.T) {
+	cfg := newTestConfig()
+
+	footer := testee.Footer(cfg, testee.Record{
+		Engine:  "chatgpt",
+		Model:   "gpt-4o-mini",
+		Usage:   llm.Usage{PromptTokens: 1000, CompletionTokens: 2000},
+		Latency: 1500 * time.Millisecond,
+	})
+	assert.Equal(t, "Tokens: 1000 prompt + 2000 completion = 3000 | Cost: $0.001350 | Latency: 1.5s", footer)
+
+	footer = testee.Footer(cfg, testee.Record{Engine: "ollama", Model: "gemma2", Usage: llm.Usage{PromptTokens: 1, Estimated: true}})
+	assert.Contains(t, footer, "(estimated)")
+	assert.Contains(t, footer, "Cost: n/a")
+}
+
+func TestAggregate(t *testing.T) {
+	startedAt := time.Now()
+	sessions := []*session.Session{
+		{Turns: []session.Turn{
+			{Engine: "chatgpt", Model: "gpt-4o-mini", Usage: llm.Usage{PromptTokens: 100, CompletionTokens: 200}, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second)},
+			{Engine: "ollama", Model: "gemma2", Usage: llm.Usage{PromptTokens: 10, CompletionTokens: 20, Estimated: true}, StartedAt: startedAt, FinishedAt: startedAt.Add(3 * time.Second)},
+		}},
+		{Turns: []session.Turn{
+			{Engine: "chatgpt", Model: "gpt-4o-mini", Usage: llm.Usage{PromptTokens: 300, CompletionTokens: 400}, StartedAt: startedAt, FinishedAt: startedAt.Add(3 * time.Second)},
+			{Engine: "chatgpt", Model: "gpt-4o-mini", Cached: true, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Millisecond)},
+		}},
+	}
+
+	summaries := testee.Aggregate(newTestConfig(), testee.FromSessions(sessions))
+	assert.Len(t, summaries, 2)
+	assert.Equal(t, "chatgpt", summaries[0].Engine)
+	assert.Equal(t, 2, summaries[0].Queries)
+	assert.Equal(t, 1, summaries[0].Cached)
+	assert.Equal(t, 400, summaries[0].PromptTokens)
+	assert.Equal(t, 600, summaries[0].CompletionTokens)
+	assert.True(t, summaries[0].Priced)
+	assert.InDelta(t, 0.00042, summaries[0].Cost, 1e-9)
+	assert.Equal(t, "ollama", summaries[1].Engine)
+	assert.Equal(t, 1, summaries[1].Estimated)
+	assert.False(t, summaries[1].Priced)
+
+	report := testee.Report(summaries)
+	assert.Contains(t, report, "| chatgpt/gpt-4o-mini | 2 | 1 | 400 | 600 | 0 | 0.0004 | 2s |")
+	assert.Contains(t, report, "| ollama/gemma2 | 1 | 0 | 10 | 20 | 1 | n/a | 3s |")
+	assert.Contains(t, report, "| **Total** | 3 | 1 | 410 | 620 | 1 | >= 0.0004 | 2.333s |")
+}
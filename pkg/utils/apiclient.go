@@ -1,12 +1,14 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -15,6 +17,13 @@ import (
 
 var client *retryablehttp.Client
 
+// streamClient is the API client of the streaming requests. Its underlying http.Client has no timeout, as it would
+// cut off a long stream, hence a stream is bounded by the context of the caller only.
+var streamClient *retryablehttp.Client
+
+// defaultTransport is the transport of the API client before any custom transport is set
+var defaultTransport http.RoundTripper
+
 func init() {
 	client = retryablehttp.NewClient()
 	client.RetryMax = 3
@@ -23,10 +32,19 @@ func init() {
 
 	// Set timeout on the underlying http.Client
 	client.HTTPClient.Timeout = 60 * time.Second
+	defaultTransport = client.HTTPClient.Transport
 
 	// Custom retry policy
 	client.CheckRetry = customRetryPolicy
 	client.Logger = log.GetDefaultLogger()
+
+	streamClient = retryablehttp.NewClient()
+	streamClient.RetryMax = client.RetryMax
+	streamClient.RetryWaitMin = client.RetryWaitMin
+	streamClient.RetryWaitMax = client.RetryWaitMax
+	streamClient.HTTPClient = &http.Client{Transport: defaultTransport}
+	streamClient.CheckRetry = customRetryPolicy
+	streamClient.Logger = log.GetDefaultLogger()
 }
 
 func customRetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
@@ -55,8 +73,41 @@ func customRetryPolicy(ctx context.Context, resp *http.Response, err error) (boo
 	return shouldRetry, nil
 }
 
-func APIRequestCore(method string, url string, body []byte, headers map[string]string) ([]byte, error) {
-	req, err := retryablehttp.NewRequest(method, url, bytes.NewReader(body))
+// SetTransport routes the HTTP traffic of the API client and the provider SDKs through the transport,
+// e.g. to record or replay it. A nil transport restores the default one.
+func SetTransport(transport http.RoundTripper) {
+	if transport == nil {
+		transport = defaultTransport
+	}
+	client.HTTPClient.Transport = transport
+	streamClient.HTTPClient.Transport = transport
+}
+
+// CustomTransport returns the transport set by SetTransport, or nil if the default one is in use
+func CustomTransport() http.RoundTripper {
+	if client.HTTPClient.Transport == defaultTransport {
+		return nil
+	}
+	return client.HTTPClient.Transport
+}
+
+// HTTPClient returns an HTTP client for the provider SDKs sharing the transport of the API client. It has
+// no timeout, so that long streaming responses are not cut off.
+func HTTPClient() *http.Client {
+	return &http.Client{Transport: client.HTTPClient.Transport}
+}
+
+// StatusError is returned on an unexpected HTTP status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+func APIRequestCore(ctx context.Context, method string, url string, body []byte, headers map[string]string) ([]byte, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -65,17 +116,19 @@ func APIRequestCore(method string, url string, body []byte, headers map[string]s
 		req.Header.Set(key, value)
 	}
 
-	log.Infof("[API] ====> : %s %s", method, url)
+	// the credentials in the query parameters are kept out of the logs
+	logURL := RedactURL(req.URL, redactionOf(ctx).params...)
+	log.Infof("[API] ====> : %s %s", method, logURL)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
+		return nil, fmt.Errorf("error making request: %w", err)
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
-	log.Infof("[API] <==== : %s %s - %d", method, url, resp.StatusCode)
+	log.Infof("[API] <==== : %s %s - %d", method, logURL, resp.StatusCode)
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -84,13 +137,13 @@ func APIRequestCore(method string, url string, body []byte, headers map[string]s
 	log.Infof("[API] Response: %s", string(responseBody))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return responseBody, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return responseBody, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	return responseBody, nil
 }
 
-func APIPost[request any, response any](url string, body request, headers map[string]string) (*response, error) {
+func APIPost[request any, response any](ctx context.Context, url string, body request, headers map[string]string) (*response, error) {
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
 		log.Errorf("error making request: %v", err)
@@ -102,7 +155,7 @@ func APIPost[request any, response any](url string, body request, headers map[st
 	}
 	headers["Content-Type"] = "application/json"
 
-	responseBody, err := APIRequestCore(http.MethodPost, url, jsonBody, headers)
+	responseBody, err := APIRequestCore(ctx, http.MethodPost, url, jsonBody, headers)
 	if err != nil {
 		log.Errorf("error making request: %v", err)
 		return nil, err
@@ -118,8 +171,8 @@ func APIPost[request any, response any](url string, body request, headers map[st
 	return &result, nil
 }
 
-func APIGet[response any](url string, headers map[string]string) (*response, error) {
-	responseBody, err := APIRequestCore(http.MethodGet, url, nil, headers)
+func APIGet[response any](ctx context.Context, url string, headers map[string]string) (*response, error) {
+	responseBody, err := APIRequestCore(ctx, http.MethodGet, url, nil, headers)
 	if err != nil {
 		log.Errorf("error making request: %v", err)
 		return nil, err
@@ -134,3 +187,62 @@ func APIGet[response any](url string, headers map[string]string) (*response, err
 
 	return &result, nil
 }
+
+// APIPostStream posts a JSON body and feeds every server-sent event data payload into onEvent
+// until the stream is closed or the "[DONE]" marker is received. The stream is not subject to the timeout
+// of the API client, only to the context.
+func APIPostStream[request any](ctx context.Context, url string, body request, headers map[string]string, onEvent func(data []byte) error) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshaling request body: %v", err)
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	logURL := RedactURL(req.URL, redactionOf(ctx).params...)
+	log.Infof("[API] ====> : %s %s (stream)", http.MethodPost, logURL)
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	log.Infof("[API] <==== : %s %s - %d", http.MethodPost, logURL, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(resp.Body)
+		log.Infof("[API] Response: %s", string(responseBody))
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		if err := onEvent([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading response stream: %v", err)
+	}
+	return nil
+}
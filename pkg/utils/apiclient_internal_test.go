@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIPostStream_LongerThanClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, "data: {\"chunk\":%d}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	timeout := client.HTTPClient.Timeout
+	client.HTTPClient.Timeout = 20 * time.Millisecond
+	defer func() {
+		client.HTTPClient.Timeout = timeout
+	}()
+
+	var events []string
+	err := APIPostStream(context.Background(), server.URL, map[string]string{}, nil, func(data []byte) error {
+		events = append(events, string(data))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"chunk":0}`, `{"chunk":1}`, `{"chunk":2}`}, events)
+
+	// the stream is still bounded by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 70*time.Millisecond)
+	defer cancel()
+	err = APIPostStream(ctx, server.URL, map[string]string{}, nil, func(data []byte) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, context.DeadlineExceeded.Error())
+}
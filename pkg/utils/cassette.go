@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	CassetteRecord = "record" // Forward the requests and capture the interactions
+	CassetteReplay = "replay" // Serve the captured interactions without network
+
+	redacted = "REDACTED"
+)
+
+var (
+	// sensitiveHeaders are the headers carrying the credentials of the providers
+	sensitiveHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key", "Api-Key", "Openai-Organization", "Cookie", "Set-Cookie"}
+	// sensitiveParams are the query parameters carrying the credentials of the providers
+	sensitiveParams = []string{"key", "api_key"}
+)
+
+type redactionKey struct{}
+
+// redaction is the headers and query parameters to redact from the requests made with a context
+type redaction struct {
+	headers []string
+	params  []string
+}
+
+// WithRedaction returns a copy of ctx, the requests made with which have the headers and query parameters redacted
+// from the logs and the recorded interactions, e.g. the auth header configured for an engine
+func WithRedaction(ctx context.Context, headers []string, params []string) context.Context {
+	return context.WithValue(ctx, redactionKey{}, redaction{headers: headers, params: params})
+}
+
+// redactionOf returns the headers and query parameters to redact from the requests made with ctx
+func redactionOf(ctx context.Context) redaction {
+	r, _ := ctx.Value(redactionKey{}).(redaction)
+	return r
+}
+
+// RedactHeaders returns the values of the headers, with the ones carrying credentials and the named ones redacted
+func RedactHeaders(header http.Header, names ...string) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(header))
+	for key, values := range header {
+		result[key] = strings.Join(values, ", ")
+	}
+	for _, key := range slices.Concat(sensitiveHeaders, names) {
+		if _, ok := result[http.CanonicalHeaderKey(key)]; ok {
+			result[http.CanonicalHeaderKey(key)] = redacted
+		}
+	}
+	return result
+}
+
+// RedactURL returns the URL, with the values of the query parameters carrying credentials and the named ones redacted
+func RedactURL(u *url.URL, names ...string) string {
+	query := u.Query()
+	changed := false
+	for _, key := range slices.Concat(sensitiveParams, names) {
+		if query.Has(key) {
+			query.Set(key, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+	return redactedURL.String()
+}
+
+// Interaction is a captured request/response pair
+type Interaction struct {
+	Request  RecordedRequest  `yaml:"request"`
+	Response RecordedResponse `yaml:"response"`
+}
+
+type RecordedRequest struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int               `yaml:"status_code"`
+	Headers    map[string]string `yaml:"headers,omitempty"`
+	Body       string            `yaml:"body,omitempty"`
+}
+
+// Cassette is an http.RoundTripper recording the HTTP traffic into a file with the credentials redacted,
+// or replaying it from the file. Interactions are replayed in the recorded order of each method and URL.
+type Cassette struct {
+	Interactions []Interaction `yaml:"interactions"`
+
+	mu   sync.Mutex
+	file string
+	mode string
+	next http.RoundTripper // Transport to forward the requests to in record mode
+	used []bool            // Interactions served in replay mode
+}
+
+// NewCassetteRecorder creates an empty cassette forwarding the requests to next, or the default transport if nil.
+// Call Save to write the captured interactions into the file.
+func NewCassetteRecorder(file string, next http.RoundTripper) *Cassette {
+	if next == nil {
+		next = defaultTransport
+	}
+	return &Cassette{file: file, mode: CassetteRecord, next: next}
+}
+
+// LoadCassette loads the cassette file to replay it
+func LoadCassette(file string) (*Cassette, error) {
+	cassette, err := LoadConfig[Cassette](file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cassette %s: %v", file, err)
+	}
+	cassette.file = file
+	cassette.mode = CassetteReplay
+	cassette.used = make([]bool, len(cassette.Interactions))
+	return cassette, nil
+}
+
+// NewCassette opens the cassette file in the mode, either CassetteRecord or CassetteReplay
+func NewCassette(file string, mode string) (*Cassette, error) {
+	switch mode {
+	case CassetteRecord:
+		return NewCassetteRecorder(file, nil), nil
+	case CassetteReplay:
+		return LoadCassette(file)
+	default:
+		return nil, fmt.Errorf("unsupported cassette mode: %s", mode)
+	}
+}
+
+// Mode returns either CassetteRecord or CassetteReplay
+func (c *Cassette) Mode() string {
+	return c.mode
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.mode == CassetteReplay {
+		return c.replay(req)
+	}
+	return c.record(req)
+}
+
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	method, reqURL := req.Method, RedactURL(req.URL, redactionOf(req.Context()).params...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, item := range c.Interactions {
+		if c.used[i] || item.Request.Method != method || item.Request.URL != reqURL {
+			continue
+		}
+		c.used[i] = true
+		return item.Response.toHTTP(req), nil
+	}
+	return nil, fmt.Errorf("no recorded interaction for %s %s in cassette %s", method, reqURL, c.file)
+}
+
+// record forwards the request and captures the interaction. The response body is read at once, hence a
+// streaming response is only passed on once it is completed.
+func (c *Cassette) record(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	redaction := redactionOf(req.Context())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, Interaction{
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     RedactURL(req.URL, redaction.params...),
+			Headers: RedactHeaders(req.Header, redaction.headers...),
+			Body:    string(reqBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    RedactHeaders(resp.Header, redaction.headers...),
+			Body:       string(respBody),
+		},
+	})
+	return resp, nil
+}
+
+// Save writes the recorded interactions into the cassette file
+func (c *Cassette) Save() error {
+	if c.mode != CassetteRecord {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.file, data, 0o600)
+}
+
+func (r RecordedResponse) toHTTP(req *http.Request) *http.Response {
+	header := http.Header{}
+	for key, value := range r.Headers {
+		header.Set(key, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
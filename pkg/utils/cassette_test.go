@@ -0,0 +1,119 @@
+package utils_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	testee "github.com/robinmin/askllm/pkg/utils"
+)
+
+func TestCassette(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"call":%d,"path":%q}`, calls, r.URL.Path)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "cassette.yaml")
+	headers := map[string]string{"Authorization": "Bearer secret-token", "X-Api-Key": "secret-key"}
+
+	t.Run("Record", func(t *testing.T) {
+		recorder := testee.NewCassetteRecorder(file, nil)
+		testee.SetTransport(recorder)
+		defer testee.SetTransport(nil)
+		assert.Equal(t, recorder, testee.CustomTransport())
+
+		body, err := testee.APIRequestCore(context.Background(), http.MethodGet, server.URL+"/models?key=secret-param", nil, headers)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"call":1,"path":"/models"}`, string(body))
+		body, err = testee.APIRequestCore(context.Background(), http.MethodPost, server.URL+"/chat", []byte(`{"q":1}`), headers)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"call":2,"path":"/chat"}`, string(body))
+		assert.NoError(t, recorder.Save())
+
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+		assert.Contains(t, string(data), "REDACTED")
+	})
+	assert.Nil(t, testee.CustomTransport())
+
+	t.Run("Replay", func(t *testing.T) {
+		cassette, err := testee.LoadCassette(file)
+		assert.NoError(t, err)
+		assert.Len(t, cassette.Interactions, 2)
+
+		// served in the recorded order of each method and URL, not the order of the requests
+		client := &http.Client{Transport: cassette}
+		resp, err := client.Post(server.URL+"/chat", "application/json", nil)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.JSONEq(t, `{"call":2,"path":"/chat"}`, string(body))
+
+		resp, err = client.Get(server.URL + "/models?key=another-key")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		body, _ = io.ReadAll(resp.Body)
+		assert.JSONEq(t, `{"call":1,"path":"/models"}`, string(body))
+
+		// every interaction is served once
+		_, err = client.Get(server.URL + "/models?key=another-key")
+		assert.ErrorContains(t, err, "no recorded interaction")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		_, err := testee.NewCassette(file, "rewind")
+		assert.Error(t, err)
+	})
+}
+
+func TestRedact(t *testing.T) {
+	u, err := url.Parse("https://example.com/v1/models?key=k1&token=t1&limit=10")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/v1/models?key=REDACTED&limit=10&token=t1", testee.RedactURL(u))
+	assert.Equal(t, "https://example.com/v1/models?key=REDACTED&limit=10&token=REDACTED", testee.RedactURL(u, "token"))
+	// the URL itself is left as is
+	assert.Equal(t, "key=k1&token=t1&limit=10", u.RawQuery)
+
+	header := http.Header{"Authorization": {"Bearer secret"}, "X-Tenant": {"acme"}, "Content-Type": {"application/json"}}
+	assert.Equal(t, map[string]string{"Authorization": "REDACTED", "X-Tenant": "acme", "Content-Type": "application/json"}, testee.RedactHeaders(header))
+	assert.Equal(t, map[string]string{"Authorization": "REDACTED", "X-Tenant": "REDACTED", "Content-Type": "application/json"}, testee.RedactHeaders(header, "x-tenant"))
+	assert.Nil(t, testee.RedactHeaders(nil))
+}
+
+func TestAPIRequestCore_RedactedLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	ctx := testee.WithRedaction(context.Background(), nil, []string{"token"})
+	_, err := testee.APIRequestCore(ctx, http.MethodGet, server.URL+"/models?key=secret-key&token=secret-token", nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, testee.APIPostStream(ctx, server.URL+"/chat?token=secret-token", map[string]string{}, nil, func(data []byte) error {
+		return nil
+	}))
+	assert.Contains(t, logs.String(), "/models?key=REDACTED&token=REDACTED")
+	assert.Contains(t, logs.String(), "/chat?token=REDACTED")
+	assert.NotContains(t, logs.String(), "secret")
+}
@@ -49,6 +49,33 @@ func SaveConfig[T any](cfg *T, yamlFile string) error {
 	return nil
 }
 
+// ToJSONCompatible converts the maps decoded from YAML (map[interface{}]interface{}) recursively into
+// map[string]interface{}, so that the value can be marshaled as JSON.
+func ToJSONCompatible(value any) any {
+	switch v := value.(type) {
+	case map[any]any:
+		result := make(map[string]any, len(v))
+		for key, val := range v {
+			result[fmt.Sprint(key)] = ToJSONCompatible(val)
+		}
+		return result
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, val := range v {
+			result[key] = ToJSONCompatible(val)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, val := range v {
+			result[i] = ToJSONCompatible(val)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
 // WriteTempFile creates a temporary file with the given name prefix,
 // writes the provided data to it, and returns the full file path.
 // The caller is responsible for calling CleanupTempFile when done.